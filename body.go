@@ -0,0 +1,83 @@
+package pirate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrBodyTooLarge        = errors.New("request body is too large")
+	ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+)
+
+// readBody reads the request body, decoding it according to its Content-Encoding
+// (gzip or deflate). limit applies to both the body as sent and the decoded body,
+// so a small compressed body can't expand into an arbitrarily large one.
+func readBody(req *http.Request, limit int64) ([]byte, error) {
+	raw, err := readLimited(req.Body, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var decoder io.ReadCloser
+
+	switch encoding := strings.ToLower(strings.TrimSpace(req.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		return raw, nil
+
+	case "gzip", "x-gzip":
+		decoder, err = gzip.NewReader(bytes.NewReader(raw))
+
+	case "deflate":
+		// as per RFC 9110, deflate is the zlib format.
+		decoder, err = zlib.NewReader(bytes.NewReader(raw))
+
+	default:
+		return nil, fmt.Errorf("%w: '%s'", ErrUnsupportedEncoding, encoding)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not decode body: %w", err)
+	}
+
+	defer decoder.Close()
+
+	decoded, err := readLimited(decoder, limit)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode body: %w", err)
+	}
+
+	return decoded, nil
+}
+
+// readLimited reads r fully, failing with ErrBodyTooLarge if it holds more than limit bytes.
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("could not read body: %w", err)
+	}
+
+	if int64(len(data)) > limit {
+		return nil, ErrBodyTooLarge
+	}
+
+	return data, nil
+}
+
+// maxBodyBytes returns the body size limit of the handler, falling back to the server's.
+func (srv *Server) maxBodyBytes(handler Handler) int64 {
+	switch {
+	case handler.MaxBodyBytes.Value > 0:
+		return int64(handler.MaxBodyBytes.Value)
+	case srv.cfg.Server.MaxBodyBytes.Value > 0:
+		return int64(srv.cfg.Server.MaxBodyBytes.Value)
+	default:
+		return defaultMaxBodyBytes
+	}
+}
@@ -0,0 +1,161 @@
+package pirate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadBody(t *testing.T) {
+	const limit = 64
+
+	body := []byte(`{"ref":"refs/heads/main"}`)
+	bomb := bytes.Repeat([]byte("a"), 10*limit)
+
+	tests := []struct {
+		name     string
+		encoding string
+		data     []byte
+		want     []byte
+		wantErr  error
+	}{
+		{"plain body", "", body, body, nil},
+		{"identity", "identity", body, body, nil},
+		{"gzip", "gzip", compress(t, "gzip", body), body, nil},
+		{"deflate", "deflate", compress(t, "deflate", body), body, nil},
+		{"body over the limit", "", bomb, nil, ErrBodyTooLarge},
+		{"decoded body over the limit", "gzip", compress(t, "gzip", bomb), nil, ErrBodyTooLarge},
+		{"unsupported encoding", "br", body, nil, ErrUnsupportedEncoding},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(test.data))
+			if test.encoding != "" {
+				req.Header.Set("Content-Encoding", test.encoding)
+			}
+
+			got, err := readBody(req, limit)
+			if !errors.Is(err, test.wantErr) {
+				tt.Fatalf("got error '%v', want '%v'", err, test.wantErr)
+			}
+
+			if !bytes.Equal(got, test.want) {
+				tt.Fatalf("got '%s', want '%s'", got, test.want)
+			}
+		})
+	}
+
+	t.Run("corrupt gzip body", func(tt *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		req.Header.Set("Content-Encoding", "gzip")
+
+		if _, err := readBody(req, limit); err == nil {
+			tt.Fatalf("expected an error")
+		}
+	})
+}
+
+func TestHandleRequestMaxBodyBytes(t *testing.T) {
+	cfg, err := loadConfig(strings.NewReader(`
+server:
+  port: 3939
+  max-body-bytes: 32
+  logging:
+    dir: ':stdout:'
+handlers:
+  - endpoint: /small
+    name: small
+    auth:
+      validator: list
+      token: [alpha]
+    run: echo
+  - endpoint: /large
+    name: large
+    max-body-bytes: 1k
+    auth:
+      validator: list
+      token: [alpha]
+    run: echo
+`))
+	if err != nil {
+		t.Fatalf("could not load config: %v", err)
+	}
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("could not initialize server: %v", err)
+	}
+
+	defer server.Close()
+
+	body := `{"data":"` + strings.Repeat("a", 64) + `"}`
+
+	tests := []struct {
+		name     string
+		endpoint string
+		encoding string
+		want     int
+	}{
+		{"server limit", "/small", "", http.StatusRequestEntityTooLarge},
+		{"server limit applies to the decoded body", "/small", "gzip", http.StatusRequestEntityTooLarge},
+		{"handler override", "/large", "", http.StatusOK},
+		{"handler override with gzip", "/large", "gzip", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			var reader io.Reader = strings.NewReader(body)
+			if test.encoding != "" {
+				reader = bytes.NewReader(compress(tt, test.encoding, []byte(body)))
+			}
+
+			req := httptest.NewRequest(http.MethodPost, test.endpoint, reader)
+			req.Header.Set(TokenHeaderField, "alpha")
+
+			if test.encoding != "" {
+				req.Header.Set("Content-Encoding", test.encoding)
+			}
+
+			rec := httptest.NewRecorder()
+			server.HandleRequest(rec, req)
+
+			if rec.Code != test.want {
+				tt.Fatalf("got status %d, want %d", rec.Code, test.want)
+			}
+		})
+	}
+}
+
+func compress(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+
+	var writer io.WriteCloser
+
+	switch encoding {
+	case "gzip":
+		writer = gzip.NewWriter(buf)
+	case "deflate":
+		writer = zlib.NewWriter(buf)
+	default:
+		t.Fatalf("unknown encoding: %s", encoding)
+	}
+
+	if _, err := writer.Write(data); err != nil {
+		t.Fatalf("could not compress: %v", err)
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatalf("could not compress: %v", err)
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,144 @@
+package pirate
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+)
+
+// ChallengeType is a subscription handshake pirate can answer on behalf of a handler.
+type ChallengeType string
+
+const (
+	// MetaChallenge answers Meta (Facebook, Instagram, WhatsApp) GET verification requests
+	// whose hub.verify_token matches VerifyToken.
+	MetaChallenge ChallengeType = "meta"
+
+	// WebSubChallenge answers WebSub intent verification requests, for Topic only if set.
+	WebSubChallenge ChallengeType = "websub"
+
+	// SlackChallenge answers Slack url_verification events, once the request passes auth.
+	SlackChallenge ChallengeType = "slack"
+
+	// GraphChallenge echoes the validationToken of Microsoft Graph subscription requests.
+	GraphChallenge ChallengeType = "msgraph"
+)
+
+// challengeMethods are the methods each challenge is sent with.
+var challengeMethods = map[ChallengeType]string{ //nolint:gochecknoglobals
+	MetaChallenge:   http.MethodGet,
+	WebSubChallenge: http.MethodGet,
+	SlackChallenge:  http.MethodPost,
+	GraphChallenge:  http.MethodPost,
+}
+
+func (c Challenge) valid(label string, methods []string) error {
+	if c.Type == "" {
+		return nil
+	}
+
+	method, ok := challengeMethods[c.Type]
+	if !ok {
+		return fmt.Errorf("%s.type: unknown challenge '%s'", label, c.Type)
+	}
+
+	if c.Type == MetaChallenge && c.VerifyToken == "" {
+		return MustBeSetError{label + ".verify-token"}
+	}
+
+	if !slices.Contains(methods, method) {
+		return fmt.Errorf("%s: '%s' challenges are sent with %s, which is not in methods", label, c.Type, method)
+	}
+
+	return nil
+}
+
+// challengeReply is the body the handshake must be answered with.
+type challengeReply string
+
+// answer returns the reply if the request is a handshake of the challenge type.
+// Slack challenges are only answered if the request passes auth.
+func (c Challenge) answer(req *http.Request, body []byte) (challengeReply, bool) {
+	query := req.URL.Query()
+
+	switch c.Type {
+	case MetaChallenge:
+		token := query.Get("hub.verify_token")
+
+		if req.Method != http.MethodGet || query.Get("hub.mode") != "subscribe" ||
+			subtle.ConstantTimeCompare([]byte(token), []byte(c.VerifyToken)) != 1 {
+			return "", false
+		}
+
+		return challengeReply(query.Get("hub.challenge")), true
+
+	case WebSubChallenge:
+		mode := query.Get("hub.mode")
+
+		if req.Method != http.MethodGet || (mode != "subscribe" && mode != "unsubscribe") ||
+			query.Get("hub.challenge") == "" || (c.Topic != "" && query.Get("hub.topic") != c.Topic) {
+			return "", false
+		}
+
+		return challengeReply(query.Get("hub.challenge")), true
+
+	case SlackChallenge:
+		event := struct {
+			Type      string `json:"type"`
+			Challenge string `json:"challenge"`
+		}{}
+
+		if req.Method != http.MethodPost || json.Unmarshal(body, &event) != nil || event.Type != "url_verification" {
+			return "", false
+		}
+
+		return challengeReply(event.Challenge), true
+
+	case GraphChallenge:
+		if req.Method != http.MethodPost || !query.Has("validationToken") {
+			return "", false
+		}
+
+		return challengeReply(query.Get("validationToken")), true
+
+	default:
+		return "", false
+	}
+}
+
+// acceptsMethod reports whether the handler accepts requests with the method.
+func (h Handler) acceptsMethod(method string) bool {
+	if len(h.Methods) == 0 {
+		return method == defaultHandlerMethod
+	}
+
+	return slices.Contains(h.Methods, method)
+}
+
+// answerChallenge answers the handshake of the handler if the request is one.
+func (srv *Server) answerChallenge(req *http.Request, match handlerMatch, payload []byte, logger *slog.Logger) (challengeReply, bool) {
+	handler := srv.cfg.Handlers[match.index]
+
+	reply, ok := handler.Challenge.answer(req, payload)
+	if !ok {
+		return "", false
+	}
+
+	if handler.Challenge.Type == SlackChallenge {
+		ctx, cancel := context.WithTimeout(req.Context(), srv.validationTimeout)
+		defer cancel()
+
+		if err := srv.validators[match.index].Validate(ctx, req, payload); err != nil {
+			logger.Debug("challenge failed authentication", "error", err)
+			return "", false
+		}
+	}
+
+	logger.Info("answered challenge", "challenge", handler.Challenge.Type)
+
+	return reply, true
+}
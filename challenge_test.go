@@ -0,0 +1,201 @@
+package pirate
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const challengeTestConfig = `
+server:
+  port: 3939
+  logging:
+    dir: ':stdout:'
+handlers:
+  - endpoint: /meta
+    name: meta
+    provider: github
+    auth:
+      secret: 'app-secret'
+    challenge:
+      type: meta
+      verify-token: 'my-verify-token'
+    run: echo
+  - endpoint: /websub
+    name: websub
+    auth:
+      validator: list
+      token: [alpha]
+    challenge:
+      type: websub
+      topic: 'https://example.com/feed'
+    run: echo
+  - endpoint: /slack
+    name: slack
+    auth:
+      validator: hmac
+      secret: 'slack-secret'
+    challenge:
+      type: slack
+    run: echo
+  - endpoint: /graph
+    name: graph
+    auth:
+      validator: list
+      token: [alpha]
+    challenge:
+      type: msgraph
+    run: echo
+  - endpoint: /put-only
+    name: put only
+    methods: [put]
+    auth:
+      validator: list
+      token: [alpha]
+    run: echo
+`
+
+func TestHandleRequestChallenges(t *testing.T) { //nolint:funlen
+	cfg, err := loadConfig(strings.NewReader(challengeTestConfig))
+	if err != nil {
+		t.Fatalf("could not load config: %v", err)
+	}
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("could not initialize server: %v", err)
+	}
+
+	defer server.Close()
+
+	slackBody := `{"type":"url_verification","challenge":"slack-challenge"}`
+	mac := hmac.New(sha256.New, []byte("slack-secret"))
+	mac.Write([]byte(slackBody))
+	slackSignature := hex.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		header     map[string]string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "meta verification",
+			method:     http.MethodGet,
+			target:     "/meta?hub.mode=subscribe&hub.verify_token=my-verify-token&hub.challenge=1158201444",
+			wantStatus: http.StatusOK,
+			wantBody:   "1158201444",
+		},
+		{
+			name:       "meta verification with wrong token",
+			method:     http.MethodGet,
+			target:     "/meta?hub.mode=subscribe&hub.verify_token=other&hub.challenge=1158201444",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "websub verification",
+			method:     http.MethodGet,
+			target:     "/websub?hub.mode=subscribe&hub.topic=https%3A%2F%2Fexample.com%2Ffeed&hub.challenge=abc",
+			wantStatus: http.StatusOK,
+			wantBody:   "abc",
+		},
+		{
+			name:       "websub verification for another topic",
+			method:     http.MethodGet,
+			target:     "/websub?hub.mode=subscribe&hub.topic=https%3A%2F%2Fexample.com%2Fother&hub.challenge=abc",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "slack url verification",
+			method:     http.MethodPost,
+			target:     "/slack",
+			body:       slackBody,
+			header:     map[string]string{defaultHMACHeader: slackSignature},
+			wantStatus: http.StatusOK,
+			wantBody:   "slack-challenge",
+		},
+		{
+			name:       "slack url verification without a valid signature",
+			method:     http.MethodPost,
+			target:     "/slack",
+			body:       slackBody,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "graph validation",
+			method:     http.MethodPost,
+			target:     "/graph?validationToken=Validation%3A+Testing+client+application",
+			wantStatus: http.StatusOK,
+			wantBody:   "Validation: Testing client application",
+		},
+		{
+			name:       "method not accepted",
+			method:     http.MethodPost,
+			target:     "/put-only",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			req := httptest.NewRequest(test.method, test.target, strings.NewReader(test.body))
+			for key, value := range test.header {
+				req.Header.Set(key, value)
+			}
+
+			rec := httptest.NewRecorder()
+			server.HandleRequest(rec, req)
+
+			if rec.Code != test.wantStatus {
+				tt.Fatalf("got status %d, want %d", rec.Code, test.wantStatus)
+			}
+
+			if test.wantBody != "" && rec.Body.String() != test.wantBody {
+				tt.Fatalf("got body '%s', want '%s'", rec.Body.String(), test.wantBody)
+			}
+		})
+	}
+
+	t.Run("methods default to POST, and GET for GET challenges", func(tt *testing.T) {
+		for _, test := range []struct {
+			k    int
+			want []string
+		}{
+			{0, []string{http.MethodPost, http.MethodGet}},
+			{2, []string{http.MethodPost}},
+			{4, []string{http.MethodPut}},
+		} {
+			if got := cfg.Handlers[test.k].Methods; !slices.Equal(got, test.want) {
+				tt.Fatalf("(handler[%d]) got %v, want %v", test.k, got, test.want)
+			}
+		}
+	})
+
+	t.Run("meta challenge requires a verify token", func(tt *testing.T) {
+		cfgCopy := clone(cfg)
+		cfgCopy.Handlers = append([]Handler{}, cfg.Handlers...)
+		cfgCopy.Handlers[0].Challenge.VerifyToken = ""
+
+		if cfgCopy.Valid() == nil {
+			tt.Fatalf("error: should've failed")
+		}
+	})
+
+	t.Run("challenge method must be accepted", func(tt *testing.T) {
+		cfgCopy := clone(cfg)
+		cfgCopy.Handlers = append([]Handler{}, cfg.Handlers...)
+		cfgCopy.Handlers[1].Methods = []string{http.MethodPost}
+
+		if cfgCopy.Valid() == nil {
+			tt.Fatalf("error: should've failed")
+		}
+	})
+}
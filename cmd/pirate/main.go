@@ -40,10 +40,11 @@ func run(cfgPath string) error {
 	defer srv.Close()
 
 	router := chi.NewRouter()
-	router.Post("/*", srv.HandleRequest)
+	// methods are checked per handler by srv.HandleRequest.
+	router.HandleFunc("/*", srv.HandleRequest)
 
 	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
-	fmt.Println("listening on: ", addr)
+	fmt.Println("listening on: ", addr, "tls:", cfg.Server.TLS.Enabled())
 
 	httpSrv := &http.Server{
 		Addr:           addr,
@@ -52,11 +53,34 @@ func run(cfgPath string) error {
 		MaxHeaderBytes: cfg.Server.MaxHeaderBytes.Value,
 	}
 
-	if listenErr := httpSrv.ListenAndServe(); listenErr != nil {
+	if listenErr := listen(httpSrv, cfg.Server.TLS); listenErr != nil {
 		if !errors.Is(listenErr, http.ErrServerClosed) {
-			return fmt.Errorf("ListenAndServe: %w", listenErr)
+			return listenErr
 		}
 	}
 
 	return nil
 }
+
+func listen(httpSrv *http.Server, tlsCfg pirate.TLS) error {
+	if !tlsCfg.Enabled() {
+		if err := httpSrv.ListenAndServe(); err != nil {
+			return fmt.Errorf("ListenAndServe: %w", err)
+		}
+
+		return nil
+	}
+
+	serverTLS, err := tlsCfg.TLSConfig()
+	if err != nil {
+		return fmt.Errorf("could not configure tls: %w", err)
+	}
+
+	httpSrv.TLSConfig = serverTLS
+
+	if err := httpSrv.ListenAndServeTLS(tlsCfg.Cert, tlsCfg.Key); err != nil {
+		return fmt.Errorf("ListenAndServeTLS: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package pirate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// compositeValidator combines several validators. If requireAll is set every
+// validator must pass, otherwise a single one passing is enough.
+// Validators are evaluated in the order they are defined.
+type compositeValidator struct {
+	validators []Validator
+	requireAll bool
+	logger     *slog.Logger
+}
+
+// ConflictingFieldsError represents an error indicating fields that can't be set together.
+type ConflictingFieldsError struct {
+	fields []string
+}
+
+func (e ConflictingFieldsError) Error() string {
+	return fmt.Sprintf("only one of %q can be set", e.fields)
+}
+
+func newCompositeValidator(params ValidatorParams) (Validator, error) { //nolint:ireturn
+	auth, label := params.Auth, params.Label
+
+	hasAll, hasAny := len(auth.All) > 0, len(auth.Any) > 0
+	if (hasAll && hasAny) || auth.Validator != "" {
+		return nil, ConflictingFieldsError{[]string{
+			label + ".all",
+			label + ".any",
+			label + ".validator",
+		}}
+	}
+
+	rules, field := auth.Any, "any"
+	if hasAll {
+		rules, field = auth.All, "all"
+	}
+
+	validators := make([]Validator, 0, len(rules))
+
+	for k, rule := range rules {
+		validator, err := newValidator(ValidatorParams{
+			Handler: params.Handler,
+			Label:   fmt.Sprintf("%s.%s[%d]", label, field, k),
+			Auth:    rule,
+			Logger:  params.Logger,
+			User:    params.User,
+			Group:   params.Group,
+			Limits:  params.Limits,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		validators = append(validators, validator)
+	}
+
+	return &compositeValidator{
+		validators: validators,
+		requireAll: hasAll,
+		logger:     params.Logger,
+	}, nil
+}
+
+func (v *compositeValidator) Validate(ctx context.Context, req *http.Request, body []byte) error {
+	if v.requireAll {
+		v.logger.Debug("using composite validator (all)")
+
+		for _, validator := range v.validators {
+			if err := validator.Validate(ctx, req, body); err != nil {
+				return err //nolint:wrapcheck
+			}
+		}
+
+		return nil
+	}
+
+	v.logger.Debug("using composite validator (any)")
+
+	unexpected := make([]error, 0, len(v.validators))
+
+	for _, validator := range v.validators {
+		// variables set by validators that fail shouldn't leak into the script.
+		branchCtx, env := withValidatorEnv(ctx)
+
+		err := validator.Validate(branchCtx, req, body)
+		if err == nil {
+			for _, kv := range env.Vars() {
+				key, value, _ := strings.Cut(kv, "=")
+				SetEnv(ctx, key, value)
+			}
+
+			return nil
+		}
+
+		if !errors.Is(err, ErrAuthFailed) {
+			unexpected = append(unexpected, err)
+		}
+	}
+
+	if len(unexpected) > 0 {
+		return fmt.Errorf("no validator passed: %w", errors.Join(unexpected...))
+	}
+
+	return ErrAuthFailed
+}
@@ -4,8 +4,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -13,21 +16,116 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
-// Validator is the method of validation being used, either a command or a token from a list.
-type Validator string
+// ValidatorName is the method of validation being used. Besides the built-in ones
+// below, any validator registered with RegisterValidator can be referenced.
+type ValidatorName string
 
 const (
-	ListValidator    Validator = "list"
-	CommandValidator Validator = "command"
+	ListValidator             ValidatorName = "list"
+	CommandValidator          ValidatorName = "command"
+	HMACValidator             ValidatorName = "hmac"
+	StandardWebhooksValidator ValidatorName = "standard-webhooks"
+	IPValidator               ValidatorName = "ip"
+	JWTValidator              ValidatorName = "jwt"
+	ClientCertValidator       ValidatorName = "client-cert"
+)
+
+// HMACAlgorithm is the hash function used to sign the request body.
+type HMACAlgorithm string
+
+const (
+	SHA1   HMACAlgorithm = "sha1"
+	SHA256 HMACAlgorithm = "sha256"
+	SHA512 HMACAlgorithm = "sha512"
+)
+
+// SignatureEncoding is how the signature is encoded in the request header.
+type SignatureEncoding string
+
+const (
+	HexEncoding    SignatureEncoding = "hex"
+	Base64Encoding SignatureEncoding = "base64"
 )
 
 // Auth specifies the authentication of the incoming request.
-// If Validator is a ListValidator, then the token of the request must match a token of the list
-// If Validator is a CommandValidator, then the value of Run is executed and considered successful if exit code = 0.
+// If Validator is a ListValidator, then the token of the request (sent in Header, X-Authorization by default)
+// must match a token of the list, which can also be loaded from TokenFile or TokenEnv.
+// Tokens can be stored hashed (e.g: sha256:<hex>).
+// If Validator is a CommandValidator, then the value of Run (or Command, see Handler) is executed and considered
+// successful if exit code = 0.
+// If ExportEnv is set, the KEY=VALUE lines it prints are passed on to the handler's script.
+// If Validator is an HMACValidator, then the signature found in Header must match the HMAC of the
+// request body computed with Secret.
+// If Validator is a StandardWebhooksValidator, then the request must follow the Standard Webhooks spec,
+// be signed with Secret, be within Tolerance of the current time and not have been seen before.
+// If Validator is an IPValidator, then the client address must be within one of the Allow CIDRs.
+// Behind TrustedProxies, it is read from ForwardedHeader (x-forwarded-for by default, or forwarded).
+// If Validator is a JWTValidator, then the bearer token must be a JWT signed by PublicKey or one of
+// the keys of JWKS, and its claims must match Issuer, Audience and Claims.
+// If Validator is a ClientCertValidator, then the verified TLS client certificate must have
+// a common name or subject alternative name in Subjects.
+// Custom validators can read their own settings from the auth block with Decode.
+// Instead of a Validator, All or Any can be set to combine several auth blocks: the request
+// must then pass all of them or at least one of them, respectively.
 type Auth struct {
-	Token     []string  `yaml:"token"`
-	Validator Validator `yaml:"validator"`
-	Run       string    `yaml:"run"`
+	All []Auth `yaml:"all"`
+	Any []Auth `yaml:"any"`
+
+	Token      []string          `yaml:"token"`
+	TokenFile  string            `yaml:"token-file"`
+	TokenEnv   string            `yaml:"token-env"`
+	Validator  ValidatorName     `yaml:"validator"`
+	Run        string            `yaml:"run"`
+	Command    []string          `yaml:"command"`
+	ExportEnv  bool              `yaml:"export-env"`
+	Secret     string            `yaml:"secret"`
+	Header     string            `yaml:"header"`
+	Algorithm  HMACAlgorithm     `yaml:"algorithm"`
+	Encoding   SignatureEncoding `yaml:"encoding"`
+	Tolerance  Duration          `yaml:"tolerance"`
+	ReplayFile string            `yaml:"replay-file"`
+
+	Allow           []string `yaml:"allow"`
+	TrustedProxies  []string `yaml:"trusted-proxies"`
+	ForwardedHeader string   `yaml:"forwarded-header"`
+
+	PublicKey string            `yaml:"public-key"`
+	JWKS      string            `yaml:"jwks"`
+	Issuer    string            `yaml:"issuer"`
+	Audience  []string          `yaml:"audience"`
+	Claims    map[string]string `yaml:"claims"`
+	Leeway    Duration          `yaml:"leeway"`
+
+	Subjects []string `yaml:"subjects"`
+
+	node *yaml.Node
+}
+
+// UnmarshalYAML decodes the auth block, keeping the raw node around for Decode.
+func (a *Auth) UnmarshalYAML(node *yaml.Node) error {
+	type plain Auth
+
+	if err := node.Decode((*plain)(a)); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	a.node = node
+
+	return nil
+}
+
+// Decode decodes the auth block into v. It allows validators registered with
+// RegisterValidator to define their own settings.
+func (a Auth) Decode(v any) error {
+	if a.node == nil {
+		return nil
+	}
+
+	if err := a.node.Decode(v); err != nil {
+		return fmt.Errorf("could not decode auth: %w", err)
+	}
+
+	return nil
 }
 
 // Logging defines the directory where logs should be written.
@@ -35,13 +133,164 @@ type Logging struct {
 	Dir string `yaml:"dir"`
 }
 
+// ClientAuthMode is the policy the server follows for TLS client authentication.
+type ClientAuthMode string
+
+const (
+	NoClientCert               ClientAuthMode = "none"
+	RequestClientCert          ClientAuthMode = "request"
+	RequireAnyClientCert       ClientAuthMode = "require"
+	VerifyClientCertIfGiven    ClientAuthMode = "verify-if-given"
+	RequireAndVerifyClientCert ClientAuthMode = "require-and-verify"
+)
+
+// TLS defines the certificate the server uses to serve HTTPS and, optionally,
+// the CA bundle client certificates are verified against.
+type TLS struct {
+	Cert       string         `yaml:"cert"`
+	Key        string         `yaml:"key"`
+	ClientCA   string         `yaml:"client-ca"`
+	ClientAuth ClientAuthMode `yaml:"client-auth"`
+}
+
+// Enabled reports whether the server should serve HTTPS.
+func (t TLS) Enabled() bool {
+	return t.Cert != "" || t.Key != ""
+}
+
+// VerifiesClientCerts reports whether client certificates are verified against ClientCA.
+func (t TLS) VerifiesClientCerts() bool {
+	return t.Enabled() && t.ClientCA != "" &&
+		(t.ClientAuth == VerifyClientCertIfGiven || t.ClientAuth == RequireAndVerifyClientCert)
+}
+
 // Handler waits for a webhook handler to come in and runs it if authenatication passes.
+// If Provider is set, Auth defaults to the scheme the provider uses and the event
+// information of the delivery is exposed to the script.
+// Authenticated requests not matching every condition of When are acknowledged with
+// FilteredStatus and the script isn't run.
+// Only requests with one of Methods are accepted, and handshakes of Challenge are
+// answered without running the script.
+// EnvFromBody sets environment variables from paths of the JSON body.
+// If Schema is set, bodies not matching the JSON Schema file are rejected.
+// MaxBodyBytes overrides the body size limit of the server.
+// Run is written to a temp file in ScriptDir (server.script-dir by default) and run with
+// Shell (bash by default) from Workdir (pirate's working directory by default).
+// Scripts running longer than Timeout are sent SIGTERM, along with the processes they
+// started, and SIGKILL if still running after KillGracePeriod.
+// If User or Group are set (as names or ids), the script runs as them, with Limits applied.
+// Command can be set instead of Run to execute a program directly, without a shell or temp
+// file, after substituting ${VAR} in its arguments with the variables of the script.
+// Scripts only inherit the variables of pirate's environment listed in EnvPassthrough,
+// Env sets static ones. Both are merged with the ones of the server.
 type Handler struct {
-	Auth     Auth            `yaml:"auth"`
-	Endpoint string          `yaml:"endpoint"`
-	Name     string          `yaml:"name"`
-	Run      string          `yaml:"run"`
-	Policy   ExecutionPolicy `yaml:"policy,omitempty"`
+	Auth           Auth            `yaml:"auth"`
+	Endpoint       string          `yaml:"endpoint"`
+	Name           string          `yaml:"name"`
+	Run            string          `yaml:"run"`
+	Command        []string        `yaml:"command,omitempty"`
+	Policy         ExecutionPolicy `yaml:"policy,omitempty"`
+	Provider       Provider        `yaml:"provider,omitempty"`
+	When           []Condition     `yaml:"when,omitempty"`
+	FilteredStatus int             `yaml:"filtered-status,omitempty"`
+	Methods        []string        `yaml:"methods,omitempty"`
+	Challenge      Challenge       `yaml:"challenge,omitempty"`
+	Body           BodyDelivery    `yaml:"body,omitempty"`
+
+	EnvFromBody map[string]BodyEnvVar `yaml:"env-from-body,omitempty"`
+	Schema      string                `yaml:"schema,omitempty"`
+
+	MaxBodyBytes    ByteSize          `yaml:"max-body-bytes,omitempty"`
+	Timeout         Duration          `yaml:"timeout,omitempty"`
+	KillGracePeriod Duration          `yaml:"kill-grace-period,omitempty"`
+	Env             map[string]string `yaml:"env,omitempty"`
+	EnvPassthrough  []string          `yaml:"env-passthrough,omitempty"`
+	Shell           string            `yaml:"shell,omitempty"`
+	Workdir         string            `yaml:"workdir,omitempty"`
+	ScriptDir       string            `yaml:"script-dir,omitempty"`
+	User            string            `yaml:"user,omitempty"`
+	Group           string            `yaml:"group,omitempty"`
+	Limits          Limits            `yaml:"limits,omitempty"`
+}
+
+// Limits are resource limits of a handler's script, inherited by the processes it starts.
+// Unset (zero) limits are left as they are. They are applied with prlimit (util-linux).
+type Limits struct {
+	// CPU is the CPU time the script can use, rounded up to seconds (RLIMIT_CPU).
+	CPU Duration `yaml:"cpu,omitempty"`
+
+	// Memory is the maximum size of the virtual memory of each process (RLIMIT_AS).
+	Memory ByteSize `yaml:"memory,omitempty"`
+
+	// OpenFiles is the maximum number of open files of each process (RLIMIT_NOFILE).
+	OpenFiles int `yaml:"open-files,omitempty"`
+
+	// Processes is the maximum number of processes of the user the script runs as (RLIMIT_NPROC).
+	Processes int `yaml:"processes,omitempty"`
+}
+
+// Enabled reports whether any limit is set.
+func (l Limits) Enabled() bool {
+	return l != Limits{}
+}
+
+// ShellArgs splits Shell into the interpreter and its arguments, e.g: "sh -eu".
+func (h Handler) ShellArgs() []string {
+	return strings.Fields(h.Shell)
+}
+
+// BodyEnvVar is an environment variable set from the JSON body. The request fails
+// if the body lacks Path, unless Default is set.
+type BodyEnvVar struct {
+	Path    string  `yaml:"path"`
+	Default *string `yaml:"default,omitempty"`
+}
+
+// UnmarshalYAML allows setting the path alone, e.g: `REF: $.ref`.
+func (v *BodyEnvVar) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		v.Path = node.Value
+		return nil
+	}
+
+	type plain BodyEnvVar
+
+	return node.Decode((*plain)(v)) //nolint:wrapcheck
+}
+
+// BodyDelivery is how the request body is passed to the script of a handler.
+type BodyDelivery string
+
+const (
+	// BodyEnv sets PIRATE_BODY, large bodies can exceed the limits of the environment.
+	BodyEnv BodyDelivery = "env"
+
+	// BodyStdin passes the body as the standard input of the script.
+	BodyStdin BodyDelivery = "stdin"
+
+	// BodyFile writes the body to a temp file, removed once the script exits, and sets PIRATE_BODY_FILE.
+	BodyFile BodyDelivery = "file"
+)
+
+// Challenge is a subscription handshake answered synchronously, before any job runs.
+// VerifyToken is required by MetaChallenge, Topic optionally restricts WebSubChallenge.
+type Challenge struct {
+	Type        ChallengeType `yaml:"type"`
+	VerifyToken string        `yaml:"verify-token,omitempty"`
+	Topic       string        `yaml:"topic,omitempty"`
+}
+
+// Condition checks a value of the request. Exactly one of Header, Query or Body
+// (a JSON path, e.g: $.ref) selects the value and exactly one of Equals, Glob,
+// Regex or In tests it.
+type Condition struct {
+	Header string   `yaml:"header,omitempty"`
+	Query  string   `yaml:"query,omitempty"`
+	Body   string   `yaml:"body,omitempty"`
+	Equals string   `yaml:"equals,omitempty"`
+	Glob   string   `yaml:"glob,omitempty"`
+	Regex  string   `yaml:"regex,omitempty"`
+	In     []string `yaml:"in,omitempty"`
 }
 
 type ExecutionPolicy string
@@ -55,18 +304,25 @@ const (
 // Config defines the configuration for the pirate server and its handlers.
 type Config struct {
 	Server struct {
-		Host           string   `yaml:"host"`
-		Port           int      `yaml:"port"`
-		Logging        Logging  `yaml:"logging"`
-		RequestTimeout Duration `yaml:"request-timeout"`
-		MaxHeaderBytes ByteSize `yaml:"max-header-bytes"`
+		Host            string            `yaml:"host"`
+		Port            int               `yaml:"port"`
+		Logging         Logging           `yaml:"logging"`
+		RequestTimeout  Duration          `yaml:"request-timeout"`
+		MaxHeaderBytes  ByteSize          `yaml:"max-header-bytes"`
+		MaxBodyBytes    ByteSize          `yaml:"max-body-bytes"`
+		ScriptDir       string            `yaml:"script-dir"`
+		HandlerTimeout  Duration          `yaml:"handler-timeout"`
+		KillGracePeriod Duration          `yaml:"kill-grace-period"`
+		Env             map[string]string `yaml:"env"`
+		EnvPassthrough  []string          `yaml:"env-passthrough"`
+		TLS             TLS               `yaml:"tls"`
 	} `yaml:"server"`
 	Handlers []Handler `yaml:"handlers"`
 }
 
 // Valid will fail if fields are missing.
 // Note that it expects optional fields to be set before being called.
-func (cfg Config) Valid() error { //nolint:gocognit
+func (cfg Config) Valid() error {
 	if cfg.Server.Host == "" {
 		return MustBeSetError{"host"}
 	}
@@ -82,37 +338,214 @@ func (cfg Config) Valid() error { //nolint:gocognit
 		return MustBeSetError{"server.max-header-bytes"}
 	}
 
+	if cfg.Server.MaxBodyBytes.Value <= 0 {
+		return MustBeSetError{"server.max-body-bytes"}
+	}
+
+	if err := cfg.Server.TLS.valid("server.tls"); err != nil {
+		return err
+	}
+
+	if err := validStaticEnv("server", cfg.Server.Env, cfg.Server.EnvPassthrough); err != nil {
+		return err
+	}
+
+	names := make(map[string]int, len(cfg.Handlers))
+
 	for k, handler := range cfg.Handlers {
 		label := fmt.Sprintf("handler[%d]", k)
 		if handler.Endpoint == "" {
 			return MustBeSetError{label + ".endpoint"}
 		}
 
+		if _, err := parseEndpoint(handler.Endpoint); err != nil {
+			return fmt.Errorf("%s.endpoint: %w", label, err)
+		}
+
 		switch handler.Policy {
 		default:
 			return MustBeSetError{label + ".policy"}
 		case Queue, Parallel, Drop:
 		}
 
-		switch handler.Auth.Validator {
+		if handler.Provider != "" && !handler.Provider.Valid() {
+			return fmt.Errorf("%s.provider: unknown provider '%s'", label, handler.Provider)
+		}
+
+		if _, err := newValidator(ValidatorParams{
+			Handler: handler.Name,
+			Label:   label + ".auth",
+			Auth:    handler.Auth,
+			User:    handler.User,
+			Group:   handler.Group,
+			Limits:  handler.Limits,
+		}); err != nil {
+			return err
+		}
+
+		if handler.Auth.uses(ClientCertValidator) && !cfg.Server.TLS.VerifiesClientCerts() {
+			return fmt.Errorf(
+				"%s.auth: the %s validator requires server.tls.client-auth to be %s or %s, with a client-ca",
+				label, ClientCertValidator, VerifyClientCertIfGiven, RequireAndVerifyClientCert,
+			)
+		}
+
+		if _, err := newFilter(label+".when", handler.When); err != nil {
+			return err
+		}
+
+		if _, err := newBodyExtractor(label+".env-from-body", handler.EnvFromBody); err != nil {
+			return err
+		}
+
+		if _, err := compileSchema(label+".schema", handler.Schema); err != nil {
+			return err
+		}
+
+		if err := handler.validExecution(label); err != nil {
+			return err
+		}
+
+		if handler.MaxBodyBytes.Value < 0 {
+			return fmt.Errorf("%s.max-body-bytes: must be positive", label)
+		}
+
+		switch handler.Body {
 		default:
-			return MustBeSetError{label + ".auth.validator"}
-		case CommandValidator:
-			if strings.TrimSpace(handler.Auth.Run) == "" {
-				return MustBeSetError{label + ".auth.run"}
-			}
-		case ListValidator:
-			if len(handler.Auth.Token) == 0 {
-				return MustBeSetError{label + ".auth.tokens"}
+			return MustBeSetError{label + ".body"}
+		case BodyEnv, BodyStdin, BodyFile:
+		}
+
+		if len(handler.Methods) == 0 {
+			return MustBeSetError{label + ".methods"}
+		}
+
+		for _, method := range handler.Methods {
+			if !slices.Contains(httpMethods, method) {
+				return fmt.Errorf("%s.methods: unknown method '%s'", label, method)
 			}
 		}
 
+		if err := handler.Challenge.valid(label+".challenge", handler.Methods); err != nil {
+			return err
+		}
+
+		if http.StatusText(handler.FilteredStatus) == "" {
+			return fmt.Errorf("%s.filtered-status: invalid status code %d", label, handler.FilteredStatus)
+		}
+
 		if handler.Name == "" {
 			return MustBeSetError{label + ".name"}
 		}
 
-		if strings.TrimSpace(handler.Run) == "" {
-			return MustBeSetError{label + ".run"}
+		// handlers sharing an endpoint are told apart by name.
+		if prev, ok := names[handler.Name]; ok {
+			return fmt.Errorf("%s.name: '%s' is already used by handler[%d]", label, handler.Name, prev)
+		}
+
+		names[handler.Name] = k
+	}
+
+	return nil
+}
+
+// validExecution checks the settings the script is run with.
+func (h Handler) validExecution(label string) error {
+	if err := validScript(label, h.Run, h.Command); err != nil {
+		return err
+	}
+
+	// commands are run without a shell.
+	if len(h.Command) == 0 {
+		if len(h.ShellArgs()) == 0 {
+			return MustBeSetError{label + ".shell"}
+		}
+
+		if _, err := exec.LookPath(h.ShellArgs()[0]); err != nil {
+			return fmt.Errorf("%s.shell: %w", label, err)
+		}
+	}
+
+	if err := validStaticEnv(label, h.Env, h.EnvPassthrough); err != nil {
+		return err
+	}
+
+	if h.Workdir != "" {
+		info, err := os.Stat(h.Workdir)
+		if err != nil {
+			return fmt.Errorf("%s.workdir: %w", label, err)
+		}
+
+		if !info.IsDir() {
+			return fmt.Errorf("%s.workdir: '%s' is not a directory", label, h.Workdir)
+		}
+	}
+
+	if h.Timeout.Duration <= 0 {
+		return fmt.Errorf("%s.timeout: must be positive", label)
+	}
+
+	if h.KillGracePeriod.Duration <= 0 {
+		return fmt.Errorf("%s.kill-grace-period: must be positive", label)
+	}
+
+	if _, err := lookupCredential(h.User, h.Group); err != nil {
+		return fmt.Errorf("%s: %w", label, err)
+	}
+
+	return h.Limits.valid(label + ".limits")
+}
+
+func (l Limits) valid(label string) error {
+	if l.CPU.Duration < 0 {
+		return fmt.Errorf("%s.cpu: must be positive", label)
+	}
+
+	if l.Memory.Value < 0 {
+		return fmt.Errorf("%s.memory: must be positive", label)
+	}
+
+	if l.OpenFiles < 0 {
+		return fmt.Errorf("%s.open-files: must be positive", label)
+	}
+
+	if l.Processes < 0 {
+		return fmt.Errorf("%s.processes: must be positive", label)
+	}
+
+	if l.Enabled() {
+		if _, err := exec.LookPath(prlimitPath); err != nil {
+			return fmt.Errorf("%s: limits are applied with prlimit (util-linux): %w", label, err)
+		}
+	}
+
+	return nil
+}
+
+func (t TLS) valid(label string) error {
+	if !t.Enabled() {
+		if t.ClientCA != "" {
+			return MustBeSetError{label + ".cert"}
+		}
+
+		return nil
+	}
+
+	if t.Cert == "" {
+		return MustBeSetError{label + ".cert"}
+	}
+
+	if t.Key == "" {
+		return MustBeSetError{label + ".key"}
+	}
+
+	switch t.ClientAuth {
+	default:
+		return MustBeSetError{label + ".client-auth"}
+	case NoClientCert, RequestClientCert, RequireAnyClientCert:
+	case VerifyClientCertIfGiven, RequireAndVerifyClientCert:
+		if t.ClientCA == "" {
+			return MustBeSetError{label + ".client-ca"}
 		}
 	}
 
@@ -222,19 +655,73 @@ func loadConfig(r io.Reader) (Config, error) {
 		cfg.Server.RequestTimeout.Duration = defaultRequestTimeout
 	}
 
+	if cfg.Server.HandlerTimeout.Duration == 0 {
+		cfg.Server.HandlerTimeout.Duration = defaultHandlerTimeout
+	}
+
+	if cfg.Server.KillGracePeriod.Duration == 0 {
+		cfg.Server.KillGracePeriod.Duration = defaultKillGracePeriod
+	}
+
 	if cfg.Server.MaxHeaderBytes.Value == 0 {
 		cfg.Server.MaxHeaderBytes.Value = defaultMaxHeaderBytes // Default to 1k
 	}
 
+	if cfg.Server.MaxBodyBytes.Value == 0 {
+		cfg.Server.MaxBodyBytes.Value = defaultMaxBodyBytes
+	}
+
 	// set default values if any
 	if cfg.Server.Host == "" {
 		cfg.Server.Host = defaultHost
 	}
 
+	if cfg.Server.TLS.ClientAuth == "" {
+		cfg.Server.TLS.ClientAuth = NoClientCert
+		if cfg.Server.TLS.ClientCA != "" {
+			cfg.Server.TLS.ClientAuth = RequireAndVerifyClientCert
+		}
+	}
+
 	for k, handler := range cfg.Handlers {
 		if handler.Policy == "" {
 			cfg.Handlers[k].Policy = defaultHandlerPolicy
 		}
+
+		if handler.FilteredStatus == 0 {
+			cfg.Handlers[k].FilteredStatus = defaultFilteredStatus
+		}
+
+		if handler.Body == "" {
+			cfg.Handlers[k].Body = defaultBodyDelivery
+		}
+
+		if handler.Shell == "" {
+			cfg.Handlers[k].Shell = defaultShell
+		}
+
+		if handler.ScriptDir == "" {
+			cfg.Handlers[k].ScriptDir = cfg.Server.ScriptDir
+		}
+
+		if handler.Timeout.Duration == 0 {
+			cfg.Handlers[k].Timeout = cfg.Server.HandlerTimeout
+		}
+
+		cfg.Handlers[k].Env = mergeEnv(cfg.Server.Env, handler.Env)
+		cfg.Handlers[k].EnvPassthrough = mergePassthrough(cfg.Server.EnvPassthrough, handler.EnvPassthrough)
+
+		if handler.KillGracePeriod.Duration == 0 {
+			cfg.Handlers[k].KillGracePeriod = cfg.Server.KillGracePeriod
+		}
+
+		setMethodDefaults(&cfg.Handlers[k])
+
+		if handler.Provider.Valid() {
+			handler.Provider.ApplyAuth(&cfg.Handlers[k].Auth)
+		}
+
+		setAuthDefaults(&cfg.Handlers[k].Auth)
 	}
 
 	if err := cfg.Valid(); err != nil {
@@ -244,6 +731,56 @@ func loadConfig(r io.Reader) (Config, error) {
 	return cfg, nil
 }
 
+// uses reports whether the auth block, or one of the blocks it combines, uses the validator.
+func (auth Auth) uses(name ValidatorName) bool {
+	if auth.Validator == name {
+		return true
+	}
+
+	for _, nested := range slices.Concat(auth.All, auth.Any) {
+		if nested.uses(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setAuthDefaults sets the defaults of the built-in validators, including nested ones.
+func setAuthDefaults(auth *Auth) {
+	for k := range auth.All {
+		setAuthDefaults(&auth.All[k])
+	}
+
+	for k := range auth.Any {
+		setAuthDefaults(&auth.Any[k])
+	}
+
+	switch auth.Validator {
+	case HMACValidator:
+		setHMACDefaults(auth)
+	case StandardWebhooksValidator:
+		if auth.Tolerance.Duration == 0 {
+			auth.Tolerance.Duration = defaultWebhookTolerance
+		}
+	default:
+	}
+}
+
+func setHMACDefaults(auth *Auth) {
+	if auth.Header == "" {
+		auth.Header = defaultHMACHeader
+	}
+
+	if auth.Algorithm == "" {
+		auth.Algorithm = defaultHMACAlgorithm
+	}
+
+	if auth.Encoding == "" {
+		auth.Encoding = defaultHMACEncoding
+	}
+}
+
 // Duration is a wrapper around time.Duration that supports JSON and YAML marshaling/unmarshaling.
 type Duration struct {
 	time.Duration
@@ -358,3 +895,31 @@ func (b *ByteSize) UnmarshalJSON(data []byte) error {
 func (b *ByteSize) UnmarshalYAML(node *yaml.Node) error {
 	return b.UnmarshalJSON([]byte(node.Value))
 }
+
+// httpMethods are the methods a handler can accept.
+var httpMethods = []string{ //nolint:gochecknoglobals
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodOptions,
+}
+
+// setMethodDefaults upper-cases the methods, which default to POST, and GET if the challenge is sent with it.
+func setMethodDefaults(handler *Handler) {
+	for k, method := range handler.Methods {
+		handler.Methods[k] = strings.ToUpper(method)
+	}
+
+	if len(handler.Methods) > 0 {
+		return
+	}
+
+	handler.Methods = []string{defaultHandlerMethod}
+
+	if method, ok := challengeMethods[handler.Challenge.Type]; ok && method != defaultHandlerMethod {
+		handler.Methods = append(handler.Methods, method)
+	}
+}
@@ -3,6 +3,8 @@ package pirate
 import (
 	"bytes"
 	_ "embed"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -75,6 +77,18 @@ func TestLoadsDefaults(t *testing.T) {
 		}
 	})
 
+	t.Run("default handler timeout and kill grace period", func(tt *testing.T) {
+		handler := cfg.Handlers[0]
+
+		if handler.Timeout.Duration != defaultHandlerTimeout {
+			tt.Fatalf("(timeout) got '%s', want '%s'", handler.Timeout, defaultHandlerTimeout)
+		}
+
+		if handler.KillGracePeriod.Duration != defaultKillGracePeriod {
+			tt.Fatalf("(kill-grace-period) got '%s', want '%s'", handler.KillGracePeriod, defaultKillGracePeriod)
+		}
+	})
+
 	t.Run("default policy was set", func(tt *testing.T) {
 		got := cfg.Handlers[0].Policy
 		want := defaultHandlerPolicy
@@ -118,6 +132,102 @@ func TestConfigIsValid(t *testing.T) {
 		}
 	})
 
+	t.Run("should validate handler names are unique", func(tt *testing.T) {
+		cfg := clone(baseCfg)
+		cfg.Handlers = append([]Handler{}, baseCfg.Handlers...)
+		cfg.Handlers = append(cfg.Handlers, cfg.Handlers[0])
+
+		if cfg.Valid() == nil {
+			tt.Fatalf("error: should've failed")
+		}
+	})
+
+	t.Run("should validate shell", func(tt *testing.T) {
+		for _, shell := range []string{" ", "no-such-shell-for-pirate"} {
+			cfg := clone(baseCfg)
+			cfg.Handlers = append([]Handler{}, baseCfg.Handlers...)
+			cfg.Handlers[0].Shell = shell
+
+			if cfg.Valid() == nil {
+				tt.Fatalf("error: should've failed for '%s'", shell)
+			}
+		}
+	})
+
+	t.Run("should validate exactly one of run and command is set", func(tt *testing.T) {
+		for _, command := range [][]string{{"true"}, nil} {
+			cfg := clone(baseCfg)
+			cfg.Handlers = append([]Handler{}, baseCfg.Handlers...)
+			cfg.Handlers[0].Command = command
+
+			if command == nil {
+				cfg.Handlers[0].Run = ""
+			}
+
+			if cfg.Valid() == nil {
+				tt.Fatalf("error: should've failed for command %q", command)
+			}
+		}
+	})
+
+	t.Run("should validate user, group and limits", func(tt *testing.T) {
+		for _, configure := range []func(*Handler){
+			func(h *Handler) { h.User = "no-such-user-for-pirate" },
+			func(h *Handler) { h.Group = "no-such-group-for-pirate" },
+			func(h *Handler) { h.Limits.OpenFiles = -1 },
+		} {
+			cfg := clone(baseCfg)
+			cfg.Handlers = append([]Handler{}, baseCfg.Handlers...)
+			configure(&cfg.Handlers[0])
+
+			if cfg.Valid() == nil {
+				tt.Fatalf("error: should've failed")
+			}
+		}
+	})
+
+	t.Run("should validate timeout", func(tt *testing.T) {
+		cfg := clone(baseCfg)
+		cfg.Handlers = append([]Handler{}, baseCfg.Handlers...)
+		cfg.Handlers[0].Timeout = Duration{-time.Second}
+
+		if cfg.Valid() == nil {
+			tt.Fatalf("error: should've failed")
+		}
+	})
+
+	t.Run("shell is not checked for commands", func(tt *testing.T) {
+		cfg := clone(baseCfg)
+		cfg.Handlers = append([]Handler{}, baseCfg.Handlers...)
+		cfg.Handlers[0].Run = ""
+		cfg.Handlers[0].Command = []string{"true"}
+		cfg.Handlers[0].Shell = "no-such-shell-for-pirate"
+		cfg.Handlers[0].Auth = Auth{Validator: ListValidator, Token: []string{"alpha"}}
+
+		if err := cfg.Valid(); err != nil {
+			tt.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("should validate workdir is a directory", func(tt *testing.T) {
+		dir := tt.TempDir()
+		fpath := filepath.Join(dir, "file")
+
+		if err := os.WriteFile(fpath, nil, 0o600); err != nil {
+			tt.Fatalf("could not write file: %v", err)
+		}
+
+		for _, workdir := range []string{filepath.Join(dir, "missing"), fpath} {
+			cfg := clone(baseCfg)
+			cfg.Handlers = append([]Handler{}, baseCfg.Handlers...)
+			cfg.Handlers[0].Workdir = workdir
+
+			if cfg.Valid() == nil {
+				tt.Fatalf("error: should've failed for '%s'", workdir)
+			}
+		}
+	})
+
 	t.Run("should validate auth.handler.tokens when validator is list", func(tt *testing.T) {
 		tt.Run("fail if nil", func(ttt *testing.T) {
 			cfg := clone(baseCfg)
@@ -141,6 +251,101 @@ func TestConfigIsValid(t *testing.T) {
 	})
 }
 
+func TestConfigHMACIsValid(t *testing.T) {
+	baseCfg, err := loadConfig(bytes.NewReader(testFileOnlyRequired))
+	if err != nil {
+		t.Fatalf("could not load base file: %v", err)
+	}
+
+	withAuth := func(fn func(*Auth)) Config {
+		cfg := clone(baseCfg)
+		cfg.Handlers = []Handler{clone(baseCfg.Handlers[0])}
+		cfg.Handlers[0].Auth = Auth{Validator: HMACValidator, Secret: "some-secret"}
+		setHMACDefaults(&cfg.Handlers[0].Auth)
+		fn(&cfg.Handlers[0].Auth)
+
+		return cfg
+	}
+
+	t.Run("should pass with defaults", func(tt *testing.T) {
+		cfg := withAuth(func(*Auth) {})
+
+		if err := cfg.Valid(); err != nil {
+			tt.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("should validate secret", func(tt *testing.T) {
+		cfg := withAuth(func(auth *Auth) { auth.Secret = "" })
+
+		if cfg.Valid() == nil {
+			tt.Fatalf("error: should've failed")
+		}
+	})
+
+	t.Run("should validate algorithm", func(tt *testing.T) {
+		cfg := withAuth(func(auth *Auth) { auth.Algorithm = "md5" })
+
+		if cfg.Valid() == nil {
+			tt.Fatalf("error: should've failed")
+		}
+	})
+}
+
+func TestConfigTLSIsValid(t *testing.T) {
+	baseCfg, err := loadConfig(bytes.NewReader(testFileOnlyRequired))
+	if err != nil {
+		t.Fatalf("could not load base file: %v", err)
+	}
+
+	t.Run("tls is disabled by default", func(tt *testing.T) {
+		if baseCfg.Server.TLS.Enabled() {
+			tt.Fatalf("tls should be disabled")
+		}
+	})
+
+	t.Run("should validate key", func(tt *testing.T) {
+		cfg := clone(baseCfg)
+		cfg.Server.TLS = TLS{Cert: "cert.pem", ClientAuth: NoClientCert}
+
+		if cfg.Valid() == nil {
+			tt.Fatalf("error: should've failed")
+		}
+	})
+
+	t.Run("client-cert validator requires verified client certs", func(tt *testing.T) {
+		cfg := clone(baseCfg)
+		cfg.Handlers = append([]Handler{}, baseCfg.Handlers...)
+		cfg.Handlers[0].Auth = Auth{Any: []Auth{
+			{Validator: ListValidator, Token: []string{"alpha"}},
+			{Validator: ClientCertValidator, Subjects: []string{"ci.example.com"}},
+		}}
+
+		for _, mode := range []ClientAuthMode{NoClientCert, RequireAnyClientCert} {
+			cfg.Server.TLS = TLS{Cert: "cert.pem", Key: "key.pem", ClientAuth: mode}
+
+			if cfg.Valid() == nil {
+				tt.Fatalf("error: should've failed with client-auth '%s'", mode)
+			}
+		}
+
+		cfg.Server.TLS = TLS{Cert: "cert.pem", Key: "key.pem", ClientCA: "ca.pem", ClientAuth: RequireAndVerifyClientCert}
+
+		if err := cfg.Valid(); err != nil {
+			tt.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("should require client-ca when verifying client certs", func(tt *testing.T) {
+		cfg := clone(baseCfg)
+		cfg.Server.TLS = TLS{Cert: "cert.pem", Key: "key.pem", ClientAuth: RequireAndVerifyClientCert}
+
+		if cfg.Valid() == nil {
+			tt.Fatalf("error: should've failed")
+		}
+	})
+}
+
 func clone[T any](v T) T { //nolint:ireturn
 	ptr := &v
 	return *ptr
@@ -1,6 +1,9 @@
 package pirate
 
-import "time"
+import (
+	"net/http"
+	"time"
+)
 
 const (
 	// Environment variable to read the pirate config path from.
@@ -15,9 +18,42 @@ const (
 	// Default request timeout.
 	defaultRequestTimeout = 5 * time.Minute
 
+	// Default time a handler script can run for before being stopped.
+	defaultHandlerTimeout = 5 * time.Minute
+
+	// Default time a stopped script has to exit after SIGTERM before being sent SIGKILL.
+	defaultKillGracePeriod = 10 * time.Second
+
 	// Default Handler policy.
 	defaultHandlerPolicy = Queue
 
+	// Default interpreter scripts are run with.
+	defaultShell = "bash"
+
+	// Default way the request body is passed to the script.
+	defaultBodyDelivery = BodyEnv
+
+	// Default method accepted by a handler.
+	defaultHandlerMethod = http.MethodPost
+
+	// Default status of requests filtered out by a handler's conditions.
+	defaultFilteredStatus = http.StatusOK
+
 	// Default max header bytes.
 	defaultMaxHeaderBytes = 1024
+
+	// Default max body bytes (10M), applies to decoded bodies too.
+	defaultMaxBodyBytes = 10 * Megabyte
+
+	// Default header holding the HMAC signature (as sent by GitHub).
+	defaultHMACHeader = "X-Hub-Signature-256"
+
+	// Default HMAC hash function.
+	defaultHMACAlgorithm = SHA256
+
+	// Default HMAC signature encoding.
+	defaultHMACEncoding = HexEncoding
+
+	// Default tolerance between a Standard Webhooks timestamp and the current time.
+	defaultWebhookTolerance = 5 * time.Minute
 )
@@ -0,0 +1,176 @@
+package pirate
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	// paramEnvPrefix is the prefix of the env vars holding the captured parameters.
+	paramEnvPrefix = "PIRATE_PARAM_"
+
+	// wildcardParam is the name the rest of the path matched by a trailing * is captured as.
+	wildcardParam = "wildcard"
+)
+
+// endpointPattern matches request paths against a chi-style route pattern, e.g:
+// /deploy/{env}/{service} or /hooks/*. A {name} placeholder captures a path segment
+// (or the part of it between a literal prefix and suffix), {name:regexp} only
+// matches if the regexp matches the whole value, and a trailing * matches the rest
+// of the path.
+type endpointPattern struct {
+	segments []patternSegment
+	wildcard bool
+}
+
+type patternSegment struct {
+	prefix string
+	param  string
+	re     *regexp.Regexp
+	suffix string
+}
+
+func parseEndpoint(endpoint string) (endpointPattern, error) {
+	if !strings.HasPrefix(endpoint, "/") {
+		return endpointPattern{}, fmt.Errorf("endpoint '%s' must start with '/'", endpoint)
+	}
+
+	parts := strings.Split(endpoint, "/")[1:]
+	pattern := endpointPattern{segments: make([]patternSegment, 0, len(parts))}
+	seen := make(map[string]bool, len(parts))
+
+	for k, part := range parts {
+		if part == "*" && k == len(parts)-1 {
+			pattern.wildcard = true
+			break
+		}
+
+		segment, err := parseSegment(part)
+		if err != nil {
+			return endpointPattern{}, fmt.Errorf("endpoint '%s': %w", endpoint, err)
+		}
+
+		if segment.param != "" {
+			if seen[segment.param] {
+				return endpointPattern{}, fmt.Errorf("endpoint '%s': duplicate parameter '%s'", endpoint, segment.param)
+			}
+
+			seen[segment.param] = true
+		}
+
+		pattern.segments = append(pattern.segments, segment)
+	}
+
+	return pattern, nil
+}
+
+func parseSegment(part string) (patternSegment, error) {
+	start := strings.Index(part, "{")
+	if start == -1 {
+		if strings.ContainsAny(part, "}*") {
+			return patternSegment{}, fmt.Errorf("invalid segment '%s'", part)
+		}
+
+		return patternSegment{prefix: part}, nil
+	}
+
+	// find the matching brace, regexps may contain some (e.g: {id:[0-9]{3}}).
+	end, depth := -1, 0
+
+	for k := start; k < len(part) && end == -1; k++ {
+		switch part[k] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				end = k
+			}
+		}
+	}
+
+	if end == -1 {
+		return patternSegment{}, fmt.Errorf("unclosed '{' in segment '%s'", part)
+	}
+
+	segment := patternSegment{prefix: part[:start], suffix: part[end+1:]}
+	if strings.ContainsAny(segment.prefix+segment.suffix, "{}*") {
+		return patternSegment{}, fmt.Errorf("only one parameter per segment is allowed in '%s'", part)
+	}
+
+	name, expr, hasExpr := strings.Cut(part[start+1:end], ":")
+	if name == "" {
+		return patternSegment{}, fmt.Errorf("empty parameter name in segment '%s'", part)
+	}
+
+	segment.param = name
+
+	if hasExpr {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return patternSegment{}, fmt.Errorf("invalid regexp for parameter '%s': %w", name, err)
+		}
+
+		segment.re = re
+	}
+
+	return segment, nil
+}
+
+// Match reports whether the escaped path matches the pattern, returning the
+// unescaped values of the parameters if so.
+func (p endpointPattern) Match(escapedPath string) (map[string]string, bool) {
+	if !strings.HasPrefix(escapedPath, "/") {
+		return nil, false
+	}
+
+	parts := strings.Split(escapedPath, "/")[1:]
+
+	// as in chi, /hooks/* matches /hooks/ but not /hooks.
+	if (p.wildcard && len(parts) <= len(p.segments)) || (!p.wildcard && len(parts) != len(p.segments)) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+
+	for k, segment := range p.segments {
+		value, err := url.PathUnescape(parts[k])
+		if err != nil {
+			return nil, false
+		}
+
+		if segment.param == "" {
+			if value != segment.prefix {
+				return nil, false
+			}
+
+			continue
+		}
+
+		if !strings.HasPrefix(value, segment.prefix) || !strings.HasSuffix(value, segment.suffix) ||
+			len(value) <= len(segment.prefix)+len(segment.suffix) {
+			return nil, false
+		}
+
+		value = value[len(segment.prefix) : len(value)-len(segment.suffix)]
+
+		if segment.re != nil && !segment.re.MatchString(value) {
+			return nil, false
+		}
+
+		params[segment.param] = value
+	}
+
+	if p.wildcard {
+		rest, err := url.PathUnescape(strings.Join(parts[len(p.segments):], "/"))
+		if err != nil {
+			return nil, false
+		}
+
+		params[wildcardParam] = rest
+	}
+
+	return params, true
+}
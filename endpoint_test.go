@@ -0,0 +1,128 @@
+package pirate
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEndpointPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    map[string]string
+	}{
+		{"/webhooks/simple", "/webhooks/simple", map[string]string{}},
+		{"/webhooks/simple", "/webhooks/simple/", nil},
+		{"/deploy/{env}/{service}", "/deploy/prod/api", map[string]string{"env": "prod", "service": "api"}},
+		{"/deploy/{env}/{service}", "/deploy/prod", nil},
+		{"/deploy/{env}/{service}", "/deploy/prod/api/extra", nil},
+		{"/deploy/{env}/{service}", "/deploy//api", nil},
+		{"/deploy/{env}", "/deploy/my%20env", map[string]string{"env": "my env"}},
+		{"/deploy/{env}", "/deploy/a%2Fb", map[string]string{"env": "a/b"}},
+		{"/builds/{id:[0-9]+}", "/builds/42", map[string]string{"id": "42"}},
+		{"/builds/{id:[0-9]+}", "/builds/latest", nil},
+		{"/builds/{id:[0-9]{3}}", "/builds/123", map[string]string{"id": "123"}},
+		{"/files/{name}.json", "/files/report.json", map[string]string{"name": "report"}},
+		{"/files/{name}.json", "/files/report.yml", nil},
+		{"/hooks/*", "/hooks/github/push", map[string]string{"wildcard": "github/push"}},
+		{"/hooks/*", "/hooks/", map[string]string{"wildcard": ""}},
+		{"/hooks/*", "/hooks", nil},
+		{"/hooks/{provider}/*", "/hooks/github/a/b", map[string]string{"provider": "github", "wildcard": "a/b"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.pattern+" "+test.path, func(tt *testing.T) {
+			pattern, err := parseEndpoint(test.pattern)
+			if err != nil {
+				tt.Fatalf("could not parse pattern: %v", err)
+			}
+
+			got, ok := pattern.Match(test.path)
+			if ok != (test.want != nil) {
+				tt.Fatalf("got match=%v, want match=%v", ok, test.want != nil)
+			}
+
+			if ok && !maps.Equal(got, test.want) {
+				tt.Fatalf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEndpointPatternIsValid(t *testing.T) {
+	invalid := []string{
+		"deploy",
+		"/deploy/{env",
+		"/deploy/{}",
+		"/deploy/{env}{service}",
+		"/deploy/{env}/{env}",
+		"/deploy/{id:[0-9}",
+		"/hooks/*/push",
+	}
+
+	for _, endpoint := range invalid {
+		t.Run(endpoint, func(tt *testing.T) {
+			if _, err := parseEndpoint(endpoint); err == nil {
+				tt.Fatalf("expected an error")
+			}
+		})
+	}
+}
+
+func TestHandleRequestPathParams(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+
+	data := fmt.Sprintf(`
+server:
+  port: 3939
+  logging:
+    dir: ':stdout:'
+handlers:
+  - endpoint: /deploy/{env}/{service}
+    name: deploy
+    auth:
+      validator: list
+      token: [alpha]
+    run: echo "$PIRATE_PARAM_ENV $PIRATE_PARAM_SERVICE" > %[1]s.tmp && mv %[1]s.tmp %[1]s
+`, out)
+
+	cfg, err := loadConfig(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("could not load config: %v", err)
+	}
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("could not initialize server: %v", err)
+	}
+
+	defer server.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/deploy/staging/api", strings.NewReader("{}"))
+	req.Header.Set(TokenHeaderField, "alpha")
+
+	rec := httptest.NewRecorder()
+	server.HandleRequest(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	waitForFile(t, out)
+
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("could not read output: %v", err)
+	}
+
+	if want := "staging api"; strings.TrimSpace(string(got)) != want {
+		t.Fatalf("got '%s', want '%s'", strings.TrimSpace(string(got)), want)
+	}
+}
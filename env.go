@@ -0,0 +1,337 @@
+package pirate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// envName turns an arbitrary key into a valid environment variable name:
+// letters are upper-cased, digits kept and anything else replaced by '_'.
+func envName(key string) string {
+	var builder strings.Builder
+
+	builder.Grow(len(key))
+
+	for _, r := range strings.ToUpper(key) {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			builder.WriteRune(r)
+		default:
+			builder.WriteRune('_')
+		}
+	}
+
+	return builder.String()
+}
+
+// prefixedEnv turns values into PREFIX<NAME>=value variables, see envName.
+// Keys are processed in sorted order and if two of them map to the same
+// variable name, the first one wins.
+func prefixedEnv(prefix string, values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	env := make([]string, 0, len(keys))
+	seen := make(map[string]bool, len(keys))
+
+	for _, key := range keys {
+		name := prefix + envName(key)
+		if seen[name] {
+			continue
+		}
+
+		seen[name] = true
+
+		env = append(env, name+"="+values[key])
+	}
+
+	return env
+}
+
+// headerValues returns the request headers, multiple values of the same header
+// are joined with ", " as allowed by RFC 9110.
+func headerValues(header http.Header) map[string]string {
+	values := make(map[string]string, len(header))
+	for key, vals := range header {
+		values[key] = strings.Join(vals, ", ")
+	}
+
+	return values
+}
+
+// maxHeaderEnvBytes caps the size of a single PIRATE_HEADERS_<NAME> value. Larger
+// headers are only available in PIRATE_HEADERS, so a huge header can't exceed the
+// limits the kernel puts on the environment of the script.
+const maxHeaderEnvBytes = 8 * 1024
+
+// headersEnv returns PIRATE_HEADERS, holding all headers as JSON, as well as a
+// PIRATE_HEADERS_<NAME> variable per header (see headerValues and prefixedEnv),
+// except for those larger than maxHeaderEnvBytes.
+func headersEnv(values map[string]string) ([]string, error) {
+	buf := &bytes.Buffer{}
+	if err := json.NewEncoder(buf).Encode(values); err != nil {
+		return nil, fmt.Errorf("could not encode headers: %w", err)
+	}
+
+	capped := make(map[string]string, len(values))
+
+	for key, value := range values {
+		if len(value) <= maxHeaderEnvBytes {
+			capped[key] = value
+		}
+	}
+
+	env := []string{"PIRATE_HEADERS=" + strings.TrimSpace(buf.String())}
+
+	return append(env, prefixedEnv("PIRATE_HEADERS_", capped)...), nil
+}
+
+const (
+	// maxValueEnvBytes caps the size of a single <name>_<KEY> value of valuesEnv.
+	maxValueEnvBytes = 8 * 1024
+
+	// maxValuesEnvBytes caps the size of the <name> JSON variable of valuesEnv, as well as
+	// the total size of its <name>_<KEY> variables, well below the 128KiB the kernel allows
+	// per variable so large bodies can't stop the script from starting.
+	maxValuesEnvBytes = 64 * 1024
+)
+
+// valuesEnv returns <name>, holding all values as a JSON object of lists, as well as
+// a <name>_<KEY> variable per key holding its first value, see prefixedEnv.
+// <name> is skipped if larger than maxValuesEnvBytes, values larger than
+// maxValueEnvBytes are skipped, and so are the keys once the variables add up to
+// maxValuesEnvBytes.
+func valuesEnv(name string, values url.Values) ([]string, error) {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode %s: %w", name, err)
+	}
+
+	first := make(map[string]string, len(values))
+
+	for key := range values {
+		if value := values.Get(key); len(value) <= maxValueEnvBytes {
+			first[key] = value
+		}
+	}
+
+	env := make([]string, 0, 1+len(first))
+
+	if len(data) <= maxValuesEnvBytes {
+		env = append(env, name+"="+string(data))
+	}
+
+	total := 0
+
+	for _, kv := range prefixedEnv(name+"_", first) {
+		if total += len(kv); total > maxValuesEnvBytes {
+			break
+		}
+
+		env = append(env, kv)
+	}
+
+	return env, nil
+}
+
+// queryEnv returns PIRATE_QUERY and a PIRATE_QUERY_<NAME> variable per query parameter.
+func queryEnv(req *http.Request) ([]string, error) {
+	return valuesEnv("PIRATE_QUERY", req.URL.Query())
+}
+
+// formEnv returns PIRATE_FORM and a PIRATE_FORM_<NAME> variable per form field if
+// the body is form-encoded, nothing otherwise.
+func formEnv(header http.Header, body []byte) ([]string, error) {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || mediaType != "application/x-www-form-urlencoded" {
+		return nil, nil //nolint:nilerr // not a form.
+	}
+
+	// fields that could be parsed are still exposed.
+	values, parseErr := url.ParseQuery(string(body))
+
+	env, err := valuesEnv("PIRATE_FORM", values)
+	if err != nil {
+		return nil, err
+	}
+
+	if parseErr != nil {
+		return env, fmt.Errorf("could not parse some form fields: %w", parseErr)
+	}
+
+	return env, nil
+}
+
+// requestEnv returns the variables describing the request itself.
+func requestEnv(req *http.Request) ([]string, error) {
+	env := []string{
+		"PIRATE_METHOD=" + req.Method,
+		"PIRATE_PATH=" + req.URL.Path,
+		"PIRATE_QUERY_STRING=" + req.URL.RawQuery,
+		"PIRATE_REMOTE_ADDR=" + req.RemoteAddr,
+	}
+
+	headers, err := headersEnv(headerValues(req.Header))
+	if err != nil {
+		return nil, err
+	}
+
+	return append(env, headers...), nil
+}
+
+// parseEnvLines parses KEY=VALUE lines, ignoring any line that isn't one.
+func parseEnvLines(output string) []string {
+	env := make([]string, 0)
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+
+		key, _, found := strings.Cut(line, "=")
+		if !found || !isEnvName(key) {
+			continue
+		}
+
+		env = append(env, line)
+	}
+
+	return env
+}
+
+// isEnvName reports whether name is a valid environment variable name: [A-Za-z_][A-Za-z0-9_]*.
+func isEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for k, r := range name {
+		isLetter := (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') || r == '_'
+		isDigit := r >= '0' && r <= '9'
+
+		if !isLetter && (k == 0 || !isDigit) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// staticEnv returns the variables of pirate's own environment listed in passthrough
+// that are set, followed by env in sorted order.
+func staticEnv(env map[string]string, passthrough []string) []string {
+	vars := make([]string, 0, len(passthrough)+len(env))
+
+	for _, name := range passthrough {
+		if value, ok := os.LookupEnv(name); ok {
+			vars = append(vars, name+"="+value)
+		}
+	}
+
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		vars = append(vars, name+"="+env[name])
+	}
+
+	return vars
+}
+
+// mergeEnv returns the variables of server overridden by the ones of handler.
+func mergeEnv(server, handler map[string]string) map[string]string {
+	if len(server) == 0 {
+		return handler
+	}
+
+	merged := make(map[string]string, len(server)+len(handler))
+	maps.Copy(merged, server)
+	maps.Copy(merged, handler)
+
+	return merged
+}
+
+// mergePassthrough returns the names of server and handler without duplicates.
+func mergePassthrough(server, handler []string) []string {
+	if len(server) == 0 {
+		return handler
+	}
+
+	merged := append(slices.Clone(server), handler...)
+	slices.Sort(merged)
+
+	return slices.Compact(merged)
+}
+
+// validStaticEnv checks env and passthrough only hold valid environment variable names.
+func validStaticEnv(label string, env map[string]string, passthrough []string) error {
+	for name := range env {
+		if !isEnvName(name) {
+			return fmt.Errorf("%s.env: '%s' is not a valid environment variable name", label, name)
+		}
+	}
+
+	for k, name := range passthrough {
+		if !isEnvName(name) {
+			return fmt.Errorf("%s.env-passthrough[%d]: '%s' is not a valid environment variable name", label, k, name)
+		}
+	}
+
+	return nil
+}
+
+// validatorEnv collects the environment variables set by validators while
+// validating a request, they are then passed on to the handler's script.
+type validatorEnv struct {
+	mu   sync.Mutex
+	vars []string
+}
+
+type validatorEnvKey struct{}
+
+// withValidatorEnv returns a context validators can call SetEnv on.
+func withValidatorEnv(ctx context.Context) (context.Context, *validatorEnv) {
+	env := &validatorEnv{}
+	return context.WithValue(ctx, validatorEnvKey{}, env), env
+}
+
+// SetEnv exposes an environment variable to the script of the handler being validated.
+// It is meant to be called by validators from within Validate, it is a no-op otherwise.
+func SetEnv(ctx context.Context, key, value string) {
+	env, ok := ctx.Value(validatorEnvKey{}).(*validatorEnv)
+	if !ok {
+		return
+	}
+
+	env.mu.Lock()
+	env.vars = append(env.vars, key+"="+value)
+	env.mu.Unlock()
+}
+
+// Vars returns the environment variables set so far.
+func (env *validatorEnv) Vars() []string {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+
+	vars := make([]string, len(env.vars))
+	copy(vars, env.vars)
+
+	return vars
+}
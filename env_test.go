@@ -0,0 +1,117 @@
+package pirate
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestQueryAndFormEnv(t *testing.T) {
+	t.Run("query parameters", func(tt *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/?env=prod&tag=a&tag=b&dry-run=1&dry_run=0", nil)
+
+		got, err := queryEnv(req)
+		if err != nil {
+			tt.Fatalf("unexpected error: %v", err)
+		}
+
+		want := []string{
+			`PIRATE_QUERY={"dry-run":["1"],"dry_run":["0"],"env":["prod"],"tag":["a","b"]}`,
+			// dry-run sorts before dry_run so it wins the PIRATE_QUERY_DRY_RUN name.
+			"PIRATE_QUERY_DRY_RUN=1",
+			"PIRATE_QUERY_ENV=prod",
+			"PIRATE_QUERY_TAG=a",
+		}
+
+		if !slices.Equal(got, want) {
+			tt.Fatalf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("form body", func(tt *testing.T) {
+		header := http.Header{}
+		header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+		got, err := formEnv(header, []byte("command=%2Fdeploy&text=api+prod&user.name=jane"))
+		if err != nil {
+			tt.Fatalf("unexpected error: %v", err)
+		}
+
+		want := []string{
+			`PIRATE_FORM={"command":["/deploy"],"text":["api prod"],"user.name":["jane"]}`,
+			"PIRATE_FORM_COMMAND=/deploy",
+			"PIRATE_FORM_TEXT=api prod",
+			"PIRATE_FORM_USER_NAME=jane",
+		}
+
+		if !slices.Equal(got, want) {
+			tt.Fatalf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("other bodies are not parsed", func(tt *testing.T) {
+		header := http.Header{}
+		header.Set("Content-Type", "application/json")
+
+		got, err := formEnv(header, []byte(`{"command":"deploy"}`))
+		if err != nil || got != nil {
+			tt.Fatalf("got %v (error: %v), want nothing", got, err)
+		}
+	})
+}
+
+func TestStaticEnv(t *testing.T) {
+	data := `
+server:
+  port: 3939
+  logging:
+    dir: ':stdout:'
+  env:
+    DEPLOY_ENV: staging
+    REGION: eu
+  env-passthrough: [PATH, PIRATE_TEST_SECRET]
+handlers:
+  - endpoint: /deploy
+    name: deploy
+    auth:
+      validator: list
+      token: [alpha]
+    env:
+      DEPLOY_ENV: prod
+    env-passthrough: [PIRATE_TEST_SECRET, PIRATE_TEST_UNSET]
+    run: echo
+`
+
+	cfg, err := loadConfig(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("could not load config: %v", err)
+	}
+
+	t.Setenv("PATH", "/usr/bin")
+	t.Setenv("PIRATE_TEST_SECRET", "s3cret")
+
+	got := staticEnv(cfg.Handlers[0].Env, cfg.Handlers[0].EnvPassthrough)
+	want := []string{"PATH=/usr/bin", "PIRATE_TEST_SECRET=s3cret", "DEPLOY_ENV=prod", "REGION=eu"}
+
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	t.Run("names must be valid", func(tt *testing.T) {
+		for _, configure := range []func(*Config){
+			func(cfg *Config) { cfg.Server.Env = map[string]string{"NOT-VALID": "x"} },
+			func(cfg *Config) { cfg.Handlers[0].Env = map[string]string{"1ST": "x"} },
+			func(cfg *Config) { cfg.Handlers[0].EnvPassthrough = []string{"SSH AUTH SOCK"} },
+		} {
+			cfgCopy := clone(cfg)
+			cfgCopy.Handlers = append([]Handler{}, cfg.Handlers...)
+			configure(&cfgCopy)
+
+			if cfgCopy.Valid() == nil {
+				tt.Fatalf("error: should've failed")
+			}
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package pirate
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// bodyExtractor evaluates the env-from-body entries of a handler.
+type bodyExtractor []bodyEnvEntry
+
+type bodyEnvEntry struct {
+	name  string
+	path  jsonPath
+	value BodyEnvVar
+}
+
+func newBodyExtractor(label string, vars map[string]BodyEnvVar) (bodyExtractor, error) {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	extractor := make(bodyExtractor, 0, len(names))
+
+	for _, name := range names {
+		entryLabel := fmt.Sprintf("%s.%s", label, name)
+
+		if !isEnvName(name) {
+			return nil, fmt.Errorf("%s: '%s' is not a valid environment variable name", entryLabel, name)
+		}
+
+		value := vars[name]
+		if value.Path == "" {
+			return nil, MustBeSetError{entryLabel + ".path"}
+		}
+
+		path, err := parseJSONPath(value.Path)
+		if err != nil {
+			return nil, fmt.Errorf("%s.path: %w", entryLabel, err)
+		}
+
+		extractor = append(extractor, bodyEnvEntry{name: name, path: path, value: value})
+	}
+
+	return extractor, nil
+}
+
+// MissingBodyFieldError is returned when a path without a default isn't found in the body.
+type MissingBodyFieldError struct {
+	name string
+	path string
+}
+
+func (e MissingBodyFieldError) Error() string {
+	return fmt.Sprintf("%s: '%s' not found in the body", e.name, e.path)
+}
+
+// Env returns NAME=value for every entry, values that aren't strings are set as JSON.
+func (e bodyExtractor) Env(body []byte) ([]string, error) {
+	if len(e) == 0 {
+		return nil, nil
+	}
+
+	var doc any
+
+	// a body that isn't JSON has none of the paths.
+	if err := json.Unmarshal(body, &doc); err != nil {
+		doc = nil
+	}
+
+	env := make([]string, 0, len(e))
+
+	for _, entry := range e {
+		found, ok := entry.path.Lookup(doc)
+		if doc == nil {
+			ok = false
+		}
+
+		switch {
+		case ok:
+			env = append(env, entry.name+"="+jsonString(found))
+		case entry.value.Default != nil:
+			env = append(env, entry.name+"="+*entry.value.Default)
+		default:
+			return nil, MissingBodyFieldError{name: entry.name, path: entry.value.Path}
+		}
+	}
+
+	return env, nil
+}
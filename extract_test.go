@@ -0,0 +1,133 @@
+package pirate
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestBodyExtractor(t *testing.T) {
+	vars := map[string]BodyEnvVar{}
+
+	err := yaml.Unmarshal([]byte(`
+REF: $.ref
+SHA: $.head_commit.id
+FIRST_FILE: $.commits[0].added[0]
+DRY_RUN:
+  path: $.dry_run
+  default: "false"
+COMMITS: $.commits
+`), &vars)
+	if err != nil {
+		t.Fatalf("could not decode vars: %v", err)
+	}
+
+	extractor, err := newBodyExtractor("env-from-body", vars)
+	if err != nil {
+		t.Fatalf("could not create extractor: %v", err)
+	}
+
+	t.Run("paths are extracted", func(tt *testing.T) {
+		body := `{"ref":"refs/heads/main","head_commit":{"id":"cafe"},"commits":[{"added":["README.md"]}]}`
+
+		got, err := extractor.Env([]byte(body))
+		if err != nil {
+			tt.Fatalf("unexpected error: %v", err)
+		}
+
+		want := []string{
+			`COMMITS=[{"added":["README.md"]}]`,
+			"DRY_RUN=false",
+			"FIRST_FILE=README.md",
+			"REF=refs/heads/main",
+			"SHA=cafe",
+		}
+
+		if !slices.Equal(got, want) {
+			tt.Fatalf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("missing paths without a default fail", func(tt *testing.T) {
+		_, err := extractor.Env([]byte(`{"ref":"refs/heads/main"}`))
+
+		missing := MissingBodyFieldError{}
+		if !errors.As(err, &missing) {
+			tt.Fatalf("got '%v', want a MissingBodyFieldError", err)
+		}
+	})
+
+	t.Run("invalid entries", func(tt *testing.T) {
+		invalid := []map[string]BodyEnvVar{
+			{"NOT-A-NAME": {Path: "$.ref"}},
+			{"REF": {}},
+			{"REF": {Path: "$.commits[x]"}},
+		}
+
+		for _, entry := range invalid {
+			if _, err := newBodyExtractor("env-from-body", entry); err == nil {
+				tt.Fatalf("expected an error for %v", entry)
+			}
+		}
+	})
+}
+
+func TestHandleRequestEnvFromBody(t *testing.T) {
+	withRef := func(h *Handler) {
+		h.EnvFromBody = map[string]BodyEnvVar{"REF": {Path: "$.ref"}}
+	}
+
+	t.Run("variables are passed to the script", func(tt *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/env", strings.NewReader(`{"ref":"refs/heads/main"}`))
+		req.Header.Set(TokenHeaderField, "alpha")
+
+		env := scriptEnv(tt, withRef, req)
+
+		if got := env["REF"]; got != "refs/heads/main" {
+			tt.Fatalf("got '%s', want 'refs/heads/main'", got)
+		}
+	})
+
+	t.Run("missing paths are answered with 400", func(tt *testing.T) {
+		cfg, err := loadConfig(strings.NewReader(`
+server:
+  port: 3939
+  logging:
+    dir: ':stdout:'
+handlers:
+  - endpoint: /deploy
+    name: deploy
+    auth:
+      validator: list
+      token: [alpha]
+    env-from-body:
+      REF: $.ref
+    run: echo "$REF"
+`))
+		if err != nil {
+			tt.Fatalf("could not load config: %v", err)
+		}
+
+		server, err := NewServer(cfg)
+		if err != nil {
+			tt.Fatalf("could not initialize server: %v", err)
+		}
+
+		defer server.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/deploy", strings.NewReader(`{}`))
+		req.Header.Set(TokenHeaderField, "alpha")
+
+		rec := httptest.NewRecorder()
+		server.HandleRequest(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			tt.Fatalf("got status %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+}
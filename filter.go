@@ -0,0 +1,166 @@
+package pirate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// filter holds the compiled conditions of a handler, a request must match all of them.
+type filter []condition
+
+type condition struct {
+	source string
+	key    string
+	path   jsonPath
+	match  func(value string) bool
+	desc   string
+}
+
+// Sources a condition can read a value from.
+const (
+	headerSource = "header"
+	querySource  = "query"
+	bodySource   = "body"
+)
+
+func newFilter(label string, conds []Condition) (filter, error) {
+	compiled := make(filter, 0, len(conds))
+
+	for k, cond := range conds {
+		c, err := newCondition(fmt.Sprintf("%s[%d]", label, k), cond)
+		if err != nil {
+			return nil, err
+		}
+
+		compiled = append(compiled, c)
+	}
+
+	return compiled, nil
+}
+
+func newCondition(label string, cond Condition) (condition, error) {
+	sources := map[string]string{headerSource: cond.Header, querySource: cond.Query, bodySource: cond.Body}
+	c := condition{}
+
+	for source, key := range sources {
+		if key == "" {
+			continue
+		}
+
+		if c.source != "" {
+			return c, ConflictingFieldsError{[]string{label + ".header", label + ".query", label + ".body"}}
+		}
+
+		c.source, c.key = source, key
+	}
+
+	if c.source == "" {
+		return c, MustBeSetError{label + ".header"}
+	}
+
+	if c.source == bodySource {
+		parsed, err := parseJSONPath(c.key)
+		if err != nil {
+			return c, fmt.Errorf("%s.body: %w", label, err)
+		}
+
+		c.path = parsed
+	}
+
+	set := 0
+
+	if cond.Equals != "" {
+		set++
+		c.match = func(value string) bool { return value == cond.Equals }
+		c.desc = fmt.Sprintf("%s %s == %q", c.source, c.key, cond.Equals)
+	}
+
+	if cond.Glob != "" {
+		if _, err := path.Match(cond.Glob, ""); err != nil {
+			return c, fmt.Errorf("%s.glob: %w", label, err)
+		}
+
+		set++
+		c.match = func(value string) bool {
+			matched, _ := path.Match(cond.Glob, value)
+			return matched
+		}
+		c.desc = fmt.Sprintf("%s %s glob %q", c.source, c.key, cond.Glob)
+	}
+
+	if cond.Regex != "" {
+		re, err := regexp.Compile(cond.Regex)
+		if err != nil {
+			return c, fmt.Errorf("%s.regex: %w", label, err)
+		}
+
+		set++
+		c.match = re.MatchString
+		c.desc = fmt.Sprintf("%s %s regex %q", c.source, c.key, cond.Regex)
+	}
+
+	if len(cond.In) > 0 {
+		set++
+		c.match = func(value string) bool { return slices.Contains(cond.In, value) }
+		c.desc = fmt.Sprintf("%s %s in %q", c.source, c.key, cond.In)
+	}
+
+	switch set {
+	case 0:
+		return c, MustBeSetError{label + ".equals"}
+	case 1:
+		return c, nil
+	default:
+		return c, ConflictingFieldsError{[]string{label + ".equals", label + ".glob", label + ".regex", label + ".in"}}
+	}
+}
+
+// Match reports whether the request matches every condition. If not, the
+// first failing condition is returned for logging.
+func (f filter) Match(req *http.Request, body []byte) (bool, string) {
+	var (
+		doc    any
+		parsed bool
+		docErr error
+	)
+
+	for _, c := range f {
+		var value string
+
+		switch c.source {
+		case headerSource:
+			value = strings.Join(req.Header.Values(c.key), ", ")
+
+		case querySource:
+			value = req.URL.Query().Get(c.key)
+
+		case bodySource:
+			if !parsed {
+				docErr = json.Unmarshal(body, &doc)
+				parsed = true
+			}
+
+			if docErr != nil {
+				return false, c.desc + " (body is not valid JSON)"
+			}
+
+			found, ok := c.path.Lookup(doc)
+			if !ok {
+				return false, c.desc + " (path not found)"
+			}
+
+			value = jsonString(found)
+		}
+
+		if !c.match(value) {
+			return false, c.desc
+		}
+	}
+
+	return true, ""
+}
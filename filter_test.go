@@ -0,0 +1,109 @@
+package pirate
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFilterMatch(t *testing.T) {
+	conds := []Condition{
+		{Header: "X-GitHub-Event", Equals: "push"},
+		{Body: "$.ref", Glob: "refs/heads/*"},
+		{Body: "commits[0].message", Regex: `^deploy\b`},
+		{Query: "env", In: []string{"staging", "production"}},
+	}
+
+	f, err := newFilter("handler[0].when", conds)
+	if err != nil {
+		t.Fatalf("could not create filter: %v", err)
+	}
+
+	const matchingBody = `{"ref":"refs/heads/main","commits":[{"message":"deploy: new version"}]}`
+
+	tests := []struct {
+		name   string
+		event  string
+		target string
+		body   string
+		want   bool
+	}{
+		{"all conditions match", "push", "/?env=staging", matchingBody, true},
+		{"header does not match", "ping", "/?env=staging", matchingBody, false},
+		{"query is not in the list", "push", "/?env=dev", matchingBody, false},
+		{"missing query", "push", "/", matchingBody, false},
+		{"glob does not match", "push", "/?env=staging", strings.Replace(matchingBody, "refs/heads/main", "refs/tags/v1", 1), false},
+		{"regex does not match", "push", "/?env=staging", strings.Replace(matchingBody, "deploy:", "fix:", 1), false},
+		{"body path not found", "push", "/?env=staging", `{"ref":"refs/heads/main"}`, false},
+		{"body is not JSON", "push", "/?env=staging", "ref=refs/heads/main", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, test.target, nil)
+			req.Header.Set("X-GitHub-Event", test.event)
+
+			got, reason := f.Match(req, []byte(test.body))
+			if got != test.want {
+				tt.Fatalf("got %v, want %v (reason: %s)", got, test.want, reason)
+			}
+		})
+	}
+}
+
+func TestFilterIsValid(t *testing.T) {
+	tests := []struct {
+		name string
+		cond Condition
+	}{
+		{"no source", Condition{Equals: "push"}},
+		{"several sources", Condition{Header: "X-Event", Query: "event", Equals: "push"}},
+		{"no operator", Condition{Header: "X-Event"}},
+		{"several operators", Condition{Header: "X-Event", Equals: "push", Glob: "p*"}},
+		{"invalid regex", Condition{Header: "X-Event", Regex: "("}},
+		{"invalid glob", Condition{Header: "X-Event", Glob: "["}},
+		{"invalid body path", Condition{Body: "$.commits[x]", Equals: "push"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			if _, err := newFilter("when", []Condition{test.cond}); err == nil {
+				tt.Fatalf("expected an error")
+			}
+		})
+	}
+}
+
+func TestHandleRequestFiltered(t *testing.T) {
+	cfg, err := loadConfig(bytes.NewReader(testConfigFile))
+	if err != nil {
+		t.Fatalf("could not load config file: %v", err)
+	}
+
+	cfg.Handlers = cfg.Handlers[:1]
+	cfg.Handlers[0].When = []Condition{{Body: "$.action", Equals: "published"}}
+	cfg.Handlers[0].FilteredStatus = http.StatusAccepted
+
+	if err := cfg.Valid(); err != nil {
+		t.Fatalf("expected config to be valid, got: %v", err)
+	}
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("could not initialize server: %v", err)
+	}
+
+	defer server.Close()
+
+	req := httptest.NewRequest(http.MethodPost, cfg.Handlers[0].Endpoint, strings.NewReader(`{"action":"created"}`))
+	req.Header.Set(TokenHeaderField, "alpha")
+
+	rec := httptest.NewRecorder()
+	server.HandleRequest(rec, req)
+
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusAccepted)
+	}
+}
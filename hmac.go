@@ -0,0 +1,109 @@
+package pirate
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec // sha1 is still used by some providers to sign webhooks.
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// hmacValidator checks that the signature sent in the configured header matches
+// the HMAC of the request body. A leading "<algorithm>=" (e.g: "sha256=") is
+// stripped from the header value, as GitHub and Gitea-style providers send it.
+type hmacValidator struct {
+	secret    []byte
+	header    string
+	algorithm HMACAlgorithm
+	newHash   func() hash.Hash
+	encoding  SignatureEncoding
+	logger    *slog.Logger
+}
+
+func newHMACValidator(params ValidatorParams) (Validator, error) { //nolint:ireturn
+	auth, label := params.Auth, params.Label
+
+	if auth.Secret == "" {
+		return nil, MustBeSetError{label + ".secret"}
+	}
+
+	if auth.Header == "" {
+		return nil, MustBeSetError{label + ".header"}
+	}
+
+	newHash, err := hashFunc(auth.Algorithm)
+	if err != nil {
+		return nil, MustBeSetError{label + ".algorithm"}
+	}
+
+	switch auth.Encoding {
+	default:
+		return nil, MustBeSetError{label + ".encoding"}
+	case HexEncoding, Base64Encoding:
+	}
+
+	return &hmacValidator{
+		secret:    []byte(auth.Secret),
+		header:    auth.Header,
+		algorithm: auth.Algorithm,
+		newHash:   newHash,
+		encoding:  auth.Encoding,
+		logger:    params.Logger,
+	}, nil
+}
+
+func (v *hmacValidator) Validate(_ context.Context, req *http.Request, body []byte) error {
+	v.logger.Debug("using hmac validator")
+
+	value := strings.TrimSpace(req.Header.Get(v.header))
+	if value == "" {
+		return ErrAuthFailed
+	}
+
+	value = strings.TrimPrefix(value, string(v.algorithm)+"=")
+
+	got, err := decodeSignature(v.encoding, value)
+	if err != nil {
+		return ErrAuthFailed
+	}
+
+	mac := hmac.New(v.newHash, v.secret)
+	mac.Write(body)
+
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return ErrAuthFailed
+	}
+
+	return nil
+}
+
+func hashFunc(algorithm HMACAlgorithm) (func() hash.Hash, error) {
+	switch algorithm {
+	case SHA1:
+		return sha1.New, nil
+	case SHA256:
+		return sha256.New, nil
+	case SHA512:
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("unknown hmac algorithm: '%s'", algorithm)
+	}
+}
+
+func decodeSignature(encoding SignatureEncoding, value string) ([]byte, error) {
+	switch encoding {
+	case HexEncoding:
+		return hex.DecodeString(value) //nolint:wrapcheck
+	case Base64Encoding:
+		return base64.StdEncoding.DecodeString(value) //nolint:wrapcheck
+	default:
+		return nil, fmt.Errorf("unknown signature encoding: '%s'", encoding)
+	}
+}
@@ -0,0 +1,202 @@
+package pirate
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+const (
+	ForwardedHeader     = "Forwarded"
+	ForwardedForHeader  = "X-Forwarded-For"
+	forwardedForParam   = "for"
+	forwardedUnknownFor = "unknown"
+)
+
+// ipValidator passes if the client address is within one of the allowed prefixes.
+// The client address is taken from the forwarding header set by the trusted proxies
+// (X-Forwarded-For by default, or Forwarded) only when the immediate peer is a
+// trusted proxy, otherwise the peer address is used.
+type ipValidator struct {
+	allow           []netip.Prefix
+	trustedProxies  []netip.Prefix
+	forwardedHeader string
+	logger          *slog.Logger
+}
+
+func newIPValidator(params ValidatorParams) (Validator, error) { //nolint:ireturn
+	auth, label := params.Auth, params.Label
+
+	if len(auth.Allow) == 0 {
+		return nil, MustBeSetError{label + ".allow"}
+	}
+
+	allow, err := parsePrefixes(label+".allow", auth.Allow)
+	if err != nil {
+		return nil, err
+	}
+
+	trustedProxies, err := parsePrefixes(label+".trusted-proxies", auth.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	// only the header the proxies set can be trusted, the other one is passed
+	// through unchanged from the client (e.g: by nginx).
+	forwardedHeader := ForwardedForHeader
+
+	switch strings.ToLower(auth.ForwardedHeader) {
+	case "", strings.ToLower(ForwardedForHeader):
+	case strings.ToLower(ForwardedHeader):
+		forwardedHeader = ForwardedHeader
+	default:
+		return nil, fmt.Errorf(
+			"%s.forwarded-header: must be '%s' or '%s', got '%s'",
+			label, strings.ToLower(ForwardedForHeader), strings.ToLower(ForwardedHeader), auth.ForwardedHeader,
+		)
+	}
+
+	return &ipValidator{
+		allow:           allow,
+		trustedProxies:  trustedProxies,
+		forwardedHeader: forwardedHeader,
+		logger:          params.Logger,
+	}, nil
+}
+
+// parsePrefixes parses a list of CIDRs, plain addresses are treated as a single address prefix.
+func parsePrefixes(label string, entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+
+	for k, entry := range entries {
+		entry = strings.TrimSpace(entry)
+
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("%s[%d]: invalid address '%s': %w", label, k, entry, err)
+			}
+
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%s[%d]: invalid CIDR '%s': %w", label, k, entry, err)
+		}
+
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (v *ipValidator) Validate(_ context.Context, req *http.Request, _ []byte) error {
+	v.logger.Debug("using ip validator")
+
+	addr, ok := v.clientAddr(req)
+	if !ok || !containsAddr(v.allow, addr) {
+		v.logger.Debug("client address not allowed", "addr", addr.String())
+		return ErrAuthFailed
+	}
+
+	return nil
+}
+
+// clientAddr determines the address of the client. Forwarding headers are walked
+// from the closest hop to the furthest one, skipping trusted proxies, so a client
+// can't spoof its address by sending the headers itself.
+func (v *ipValidator) clientAddr(req *http.Request) (netip.Addr, bool) {
+	peer, ok := parseAddr(req.RemoteAddr)
+	if !ok {
+		return netip.Addr{}, false
+	}
+
+	if !containsAddr(v.trustedProxies, peer) {
+		return peer, true
+	}
+
+	hops := forwardedFor(req.Header, v.forwardedHeader)
+	if len(hops) == 0 {
+		return peer, true
+	}
+
+	for k := len(hops) - 1; k >= 0; k-- {
+		addr, ok := parseAddr(hops[k])
+		if !ok {
+			return netip.Addr{}, false
+		}
+
+		if !containsAddr(v.trustedProxies, addr) {
+			return addr, true
+		}
+	}
+
+	// every hop is a trusted proxy, so the furthest one is the client.
+	return parseAddr(hops[0])
+}
+
+// forwardedFor returns the list of hops in the forwarding header, either the
+// Forwarded or the X-Forwarded-For header. Hops are ordered from client to proxy.
+func forwardedFor(header http.Header, name string) []string {
+	hops := make([]string, 0)
+
+	if name != ForwardedHeader {
+		for _, value := range header.Values(ForwardedForHeader) {
+			for _, hop := range strings.Split(value, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+
+		return hops
+	}
+
+	for _, value := range header.Values(ForwardedHeader) {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(key, forwardedForParam) {
+					hops = append(hops, strings.Trim(val, `"`))
+				}
+			}
+		}
+	}
+
+	return hops
+}
+
+// parseAddr parses an address which may contain a port and/or be enclosed in brackets.
+func parseAddr(value string) (netip.Addr, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" || value == forwardedUnknownFor {
+		return netip.Addr{}, false
+	}
+
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+
+	addr, err := netip.ParseAddr(strings.Trim(value, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return addr.Unmap(), true
+}
@@ -0,0 +1,116 @@
+package pirate
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPath is a parsed path into a JSON document, e.g: $.commits[0].id or repository.full_name.
+// The leading $ is optional.
+type jsonPath []jsonPathStep
+
+type jsonPathStep struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+func parseJSONPath(path string) (jsonPath, error) {
+	trimmed := strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(path), "$"), ".")
+	if trimmed == "" {
+		return jsonPath{}, nil
+	}
+
+	steps := make(jsonPath, 0)
+
+	for _, segment := range strings.Split(trimmed, ".") {
+		key, rest, _ := strings.Cut(segment, "[")
+		if key == "" && rest == "" {
+			return nil, fmt.Errorf("invalid path '%s': empty key", path)
+		}
+
+		if key != "" {
+			steps = append(steps, jsonPathStep{key: key})
+		}
+
+		for rest != "" {
+			value, after, found := strings.Cut(rest, "]")
+			if !found {
+				return nil, fmt.Errorf("invalid path '%s': missing ']'", path)
+			}
+
+			index, err := strconv.Atoi(value)
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid path '%s': bad index '%s'", path, value)
+			}
+
+			steps = append(steps, jsonPathStep{index: index, isIndex: true})
+
+			if after != "" && !strings.HasPrefix(after, "[") {
+				return nil, fmt.Errorf("invalid path '%s': unexpected '%s'", path, after)
+			}
+
+			rest = strings.TrimPrefix(after, "[")
+		}
+	}
+
+	return steps, nil
+}
+
+// Lookup returns the value at the path in a document decoded with encoding/json.
+func (p jsonPath) Lookup(doc any) (any, bool) {
+	current := doc
+
+	for _, step := range p {
+		if step.isIndex {
+			arr, ok := current.([]any)
+			if !ok || step.index >= len(arr) {
+				return nil, false
+			}
+
+			current = arr[step.index]
+
+			continue
+		}
+
+		obj, ok := current.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+
+		if current, ok = obj[step.key]; !ok {
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+// lookupJSON is a shorthand for paths known to be valid.
+func lookupJSON(doc any, path string) (any, bool) {
+	parsed, err := parseJSONPath(path)
+	if err != nil {
+		return nil, false
+	}
+
+	return parsed.Lookup(doc)
+}
+
+// jsonString returns strings as is, null as an empty string and any other value as JSON.
+func jsonString(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return ""
+		}
+
+		return string(data)
+	}
+}
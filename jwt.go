@@ -0,0 +1,520 @@
+package pirate
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	AuthorizationHeader = "Authorization"
+	bearerPrefix        = "Bearer "
+	claimEnvPrefix      = "PIRATE_CLAIM_"
+)
+
+// jwtValidator verifies a JWT sent as a bearer token against a set of public keys,
+// then checks its registered claims (exp, nbf, iss, aud) and any required ones.
+// The claims of a valid token are exposed to the script as PIRATE_CLAIM_<NAME>.
+type jwtValidator struct {
+	keys     []jwtKey
+	issuer   string
+	audience []string
+	claims   map[string]string
+	leeway   time.Duration
+	logger   *slog.Logger
+
+	// now is overridden in tests.
+	now func() time.Time
+}
+
+type jwtKey struct {
+	id  string
+	key crypto.PublicKey
+}
+
+func newJWTValidator(params ValidatorParams) (Validator, error) { //nolint:ireturn
+	auth, label := params.Auth, params.Label
+
+	if auth.PublicKey == "" && auth.JWKS == "" {
+		return nil, MustBeSetError{label + ".public-key"}
+	}
+
+	keys := make([]jwtKey, 0)
+
+	if auth.PublicKey != "" {
+		fromPEM, err := loadPEMKeys(auth.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("%s.public-key: %w", label, err)
+		}
+
+		keys = append(keys, fromPEM...)
+	}
+
+	if auth.JWKS != "" {
+		fromJWKS, err := loadJWKS(auth.JWKS)
+		if err != nil {
+			return nil, fmt.Errorf("%s.jwks: %w", label, err)
+		}
+
+		keys = append(keys, fromJWKS...)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no usable public keys found", label)
+	}
+
+	return &jwtValidator{
+		keys:     keys,
+		issuer:   auth.Issuer,
+		audience: auth.Audience,
+		claims:   auth.Claims,
+		leeway:   auth.Leeway.Duration,
+		logger:   params.Logger,
+		now:      time.Now,
+	}, nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+func (v *jwtValidator) Validate(ctx context.Context, req *http.Request, _ []byte) error {
+	v.logger.Debug("using jwt validator")
+
+	value := req.Header.Get(AuthorizationHeader)
+	if len(value) <= len(bearerPrefix) || !strings.EqualFold(value[:len(bearerPrefix)], bearerPrefix) {
+		return ErrAuthFailed
+	}
+
+	claims, err := v.verify(strings.TrimSpace(value[len(bearerPrefix):]))
+	if err != nil {
+		v.logger.Debug("invalid jwt", "error", err)
+		return ErrAuthFailed
+	}
+
+	if err := v.checkClaims(claims); err != nil {
+		v.logger.Debug("invalid jwt claims", "error", err)
+		return ErrAuthFailed
+	}
+
+	exposeClaims(ctx, claims)
+
+	return nil
+}
+
+// verify checks the signature of the token and returns its claims.
+func (v *jwtValidator) verify(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+
+	const wantParts = 3
+	if len(parts) != wantParts {
+		return nil, errors.New("malformed token")
+	}
+
+	header := jwtHeader{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("could not decode header: %w", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("could not decode signature: %w", err)
+	}
+
+	signingInput := []byte(parts[0] + "." + parts[1])
+	verified := false
+
+	for _, key := range v.keys {
+		if header.Kid != "" && key.id != "" && header.Kid != key.id {
+			continue
+		}
+
+		if verifySignature(header.Alg, key.key, signingInput, signature) == nil {
+			verified = true
+			break
+		}
+	}
+
+	if !verified {
+		return nil, fmt.Errorf("signature could not be verified (alg=%s, kid=%s)", header.Alg, header.Kid)
+	}
+
+	claims := make(map[string]any)
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("could not decode claims: %w", err)
+	}
+
+	return claims, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	return decoder.Decode(v) //nolint:wrapcheck
+}
+
+var ErrUnsupportedAlgorithm = errors.New("unsupported jwt algorithm")
+
+// verifySignature checks the signature for the given algorithm, failing
+// if the key is not of the type the algorithm expects.
+func verifySignature(alg string, key crypto.PublicKey, signingInput, signature []byte) error { //nolint:cyclop
+	var hash crypto.Hash
+
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "PS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "PS512", "ES512":
+		hash = crypto.SHA512
+	case "EdDSA":
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(pub, signingInput, signature) {
+			return ErrAuthFailed
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("%w: '%s'", ErrUnsupportedAlgorithm, alg)
+	}
+
+	hasher := hash.New()
+	hasher.Write(signingInput)
+	digest := hasher.Sum(nil)
+
+	switch alg[0] {
+	case 'R':
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrAuthFailed
+		}
+
+		return rsa.VerifyPKCS1v15(pub, hash, digest, signature) //nolint:wrapcheck
+
+	case 'P':
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrAuthFailed
+		}
+
+		opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}
+
+		return rsa.VerifyPSS(pub, hash, digest, signature, opts) //nolint:wrapcheck
+
+	default:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve.Params().BitSize != ecdsaBitSize(hash) {
+			return ErrAuthFailed
+		}
+
+		size := len(signature) / 2 //nolint:mnd
+		if size == 0 || len(signature) != 2*size {
+			return ErrAuthFailed
+		}
+
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return ErrAuthFailed
+		}
+
+		return nil
+	}
+}
+
+func ecdsaBitSize(hash crypto.Hash) int {
+	switch hash { //nolint:exhaustive
+	case crypto.SHA256:
+		return 256 //nolint:mnd
+	case crypto.SHA384:
+		return 384 //nolint:mnd
+	default:
+		return 521 //nolint:mnd
+	}
+}
+
+// checkClaims validates the registered claims and the required ones.
+func (v *jwtValidator) checkClaims(claims map[string]any) error {
+	now := v.now()
+
+	exp, found, err := numericDate(claims, "exp")
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return errors.New("token has no expiry")
+	}
+
+	if now.After(exp.Add(v.leeway)) {
+		return errors.New("token has expired")
+	}
+
+	nbf, found, err := numericDate(claims, "nbf")
+	if err != nil {
+		return err
+	}
+
+	if found && now.Before(nbf.Add(-v.leeway)) {
+		return errors.New("token is not valid yet")
+	}
+
+	if v.issuer != "" && !claimHasValue(claims["iss"], v.issuer) {
+		return fmt.Errorf("unexpected issuer: %v", claims["iss"])
+	}
+
+	if len(v.audience) > 0 && !audienceMatches(claims["aud"], v.audience) {
+		return fmt.Errorf("unexpected audience: %v", claims["aud"])
+	}
+
+	for name, want := range v.claims {
+		if !claimHasValue(claims[name], want) {
+			return fmt.Errorf("claim '%s' does not match", name)
+		}
+	}
+
+	return nil
+}
+
+func numericDate(claims map[string]any, name string) (time.Time, bool, error) {
+	value, found := claims[name]
+	if !found {
+		return time.Time{}, false, nil
+	}
+
+	number, ok := value.(json.Number)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("claim '%s' is not a number", name)
+	}
+
+	seconds, err := number.Float64()
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("claim '%s' is not a number: %w", name, err)
+	}
+
+	return time.Unix(int64(seconds), 0), true, nil
+}
+
+func audienceMatches(aud any, allowed []string) bool {
+	for _, want := range allowed {
+		if claimHasValue(aud, want) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// claimHasValue reports whether the claim equals want or, if it is a list, contains it.
+func claimHasValue(claim any, want string) bool {
+	if list, ok := claim.([]any); ok {
+		for _, item := range list {
+			if claimString(item) == want {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return claim != nil && claimString(claim) == want
+}
+
+// claimString returns strings as is and any other value JSON encoded.
+func claimString(claim any) string {
+	if str, ok := claim.(string); ok {
+		return str
+	}
+
+	data, err := json.Marshal(claim)
+	if err != nil {
+		return ""
+	}
+
+	return string(data)
+}
+
+// exposeClaims sets PIRATE_CLAIM_<NAME> for every claim, see prefixedEnv.
+func exposeClaims(ctx context.Context, claims map[string]any) {
+	values := make(map[string]string, len(claims))
+	for name, claim := range claims {
+		values[name] = claimString(claim)
+	}
+
+	for _, kv := range prefixedEnv(claimEnvPrefix, values) {
+		key, value, _ := strings.Cut(kv, "=")
+		SetEnv(ctx, key, value)
+	}
+}
+
+// loadPEMKeys reads the public keys (or certificates) of a PEM file.
+func loadPEMKeys(fpath string) ([]jwtKey, error) {
+	data, err := os.ReadFile(fpath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read file: %w", err)
+	}
+
+	keys := make([]jwtKey, 0)
+
+	for {
+		var block *pem.Block
+
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		key, err := parsePEMBlock(block)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, jwtKey{key: key})
+	}
+
+	return keys, nil
+}
+
+func parsePEMBlock(block *pem.Block) (crypto.PublicKey, error) {
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes) //nolint:wrapcheck
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes) //nolint:wrapcheck
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse certificate: %w", err)
+		}
+
+		return cert.PublicKey, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM block: '%s'", block.Type)
+	}
+}
+
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// loadJWKS reads the signing keys of a JWKS file.
+func loadJWKS(fpath string) ([]jwtKey, error) {
+	data, err := os.ReadFile(fpath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read file: %w", err)
+	}
+
+	set := jwks{}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("could not parse JWKS: %w", err)
+	}
+
+	keys := make([]jwtKey, 0, len(set.Keys))
+
+	for k, entry := range set.Keys {
+		if entry.Use != "" && entry.Use != "sig" {
+			continue
+		}
+
+		key, err := entry.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("keys[%d]: %w", k, err)
+		}
+
+		keys = append(keys, jwtKey{id: entry.Kid, key: key})
+	}
+
+	return keys, nil
+}
+
+func (entry jwk) publicKey() (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+
+	switch entry.Kty {
+	case "RSA":
+		n, errN := decode(entry.N)
+		e, errE := decode(entry.E)
+
+		if err := errors.Join(errN, errE); err != nil {
+			return nil, fmt.Errorf("invalid RSA key: %w", err)
+		}
+
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+
+		switch entry.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve: '%s'", entry.Crv)
+		}
+
+		x, errX := decode(entry.X)
+		y, errY := decode(entry.Y)
+
+		if err := errors.Join(errX, errY); err != nil {
+			return nil, fmt.Errorf("invalid EC key: %w", err)
+		}
+
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+
+	case "OKP":
+		x, err := decode(entry.X)
+		if err != nil || entry.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid OKP key, only Ed25519 is supported")
+		}
+
+		return ed25519.PublicKey(x), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type: '%s'", entry.Kty)
+	}
+}
@@ -0,0 +1,195 @@
+package pirate
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestJWTValidator(t *testing.T) { //nolint:funlen
+	now := time.Now()
+	dir := t.TempDir()
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	if err != nil {
+		t.Fatalf("could not marshal key: %v", err)
+	}
+
+	pemFile := filepath.Join(dir, "key.pem")
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	if err := os.WriteFile(pemFile, pemData, filePerms); err != nil {
+		t.Fatalf("could not write pem file: %v", err)
+	}
+
+	jwksFile := filepath.Join(dir, "jwks.json")
+	jwksData, _ := json.Marshal(jwks{Keys: []jwk{{
+		Kty: "OKP",
+		Kid: "ed-key",
+		Crv: "Ed25519",
+		X:   base64.RawURLEncoding.EncodeToString(edPub),
+	}}})
+
+	if err := os.WriteFile(jwksFile, jwksData, filePerms); err != nil {
+		t.Fatalf("could not write jwks file: %v", err)
+	}
+
+	validator, err := newValidator(ValidatorParams{
+		Label: "auth",
+		Auth: Auth{
+			Validator: JWTValidator,
+			PublicKey: pemFile,
+			JWKS:      jwksFile,
+			Issuer:    "ci",
+			Audience:  []string{"pirate"},
+			Claims:    map[string]string{"role": "deployer"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("could not create validator: %v", err)
+	}
+
+	jwtv, ok := validator.(*jwtValidator)
+	if !ok {
+		t.Fatalf("unexpected validator type: %T", validator)
+	}
+
+	jwtv.now = func() time.Time { return now }
+
+	validClaims := func() map[string]any {
+		return map[string]any{
+			"iss":  "ci",
+			"aud":  []string{"other", "pirate"},
+			"exp":  now.Add(time.Minute).Unix(),
+			"role": "deployer",
+			"sub":  "build-42",
+		}
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"ES256 token signed with the PEM key", signES256(t, ecKey, validClaims()), nil},
+		{"EdDSA token signed with the JWKS key", signEdDSA(t, edKey, "ed-key", validClaims()), nil},
+		{"unknown key id", signEdDSA(t, edKey, "other-key", validClaims()), ErrAuthFailed},
+		{"expired token", signES256(t, ecKey, withClaim(validClaims(), "exp", now.Add(-time.Minute).Unix())), ErrAuthFailed},
+		{"token not valid yet", signES256(t, ecKey, withClaim(validClaims(), "nbf", now.Add(time.Minute).Unix())), ErrAuthFailed},
+		{"wrong issuer", signES256(t, ecKey, withClaim(validClaims(), "iss", "someone")), ErrAuthFailed},
+		{"wrong audience", signES256(t, ecKey, withClaim(validClaims(), "aud", "other")), ErrAuthFailed},
+		{"missing required claim", signES256(t, ecKey, withClaim(validClaims(), "role", "viewer")), ErrAuthFailed},
+		{"malformed token", "not-a-jwt", ErrAuthFailed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.Header.Set(AuthorizationHeader, "Bearer "+test.token)
+
+			if err := validator.Validate(context.Background(), req, nil); !errors.Is(err, test.want) {
+				tt.Fatalf("got '%v', want '%v'", err, test.want)
+			}
+		})
+	}
+
+	t.Run("claims are exposed as env vars", func(tt *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set(AuthorizationHeader, "Bearer "+signES256(tt, ecKey, validClaims()))
+
+		ctx, env := withValidatorEnv(context.Background())
+
+		if err := validator.Validate(ctx, req, nil); err != nil {
+			tt.Fatalf("unexpected error: %v", err)
+		}
+
+		vars := env.Vars()
+
+		for _, want := range []string{"PIRATE_CLAIM_SUB=build-42", `PIRATE_CLAIM_AUD=["other","pirate"]`} {
+			if !slices.Contains(vars, want) {
+				tt.Fatalf("expected '%s' in %v", want, vars)
+			}
+		}
+	})
+}
+
+func withClaim(claims map[string]any, name string, value any) map[string]any {
+	claims[name] = value
+	return claims
+}
+
+func signingInput(t *testing.T, header, claims map[string]any) string {
+	t.Helper()
+
+	headerData, err := json.Marshal(header)
+	if err != nil {
+		t.Fatalf("could not encode header: %v", err)
+	}
+
+	claimsData, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("could not encode claims: %v", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(headerData) + "." +
+		base64.RawURLEncoding.EncodeToString(claimsData)
+}
+
+func signES256(t *testing.T, key *ecdsa.PrivateKey, claims map[string]any) string {
+	t.Helper()
+
+	input := signingInput(t, map[string]any{"alg": "ES256", "typ": "JWT"}, claims)
+	digest := sha256.Sum256([]byte(input))
+
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatalf("could not sign token: %v", err)
+	}
+
+	const size = 32
+
+	signature := make([]byte, 2*size)
+	r.FillBytes(signature[:size])
+	s.FillBytes(signature[size:])
+
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func signEdDSA(t *testing.T, key ed25519.PrivateKey, kid string, claims map[string]any) string {
+	t.Helper()
+
+	input := signingInput(t, map[string]any{"alg": "EdDSA", "kid": kid}, claims)
+
+	signature, err := key.Sign(rand.Reader, []byte(input), crypto.Hash(0))
+	if err != nil {
+		t.Fatalf("could not sign token: %v", err)
+	}
+
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
@@ -0,0 +1,160 @@
+package pirate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Provider is a git forge whose webhooks pirate knows how to authenticate and parse.
+type Provider string
+
+const (
+	GitHub    Provider = "github"
+	GitLab    Provider = "gitlab"
+	Gitea     Provider = "gitea"
+	Bitbucket Provider = "bitbucket"
+)
+
+// Headers set by the providers.
+const (
+	GitHubEventHeader        = "X-GitHub-Event"
+	GitHubDeliveryHeader     = "X-GitHub-Delivery"
+	GitHubSignatureHeader    = "X-Hub-Signature-256"
+	GitLabEventHeader        = "X-Gitlab-Event"
+	GitLabDeliveryHeader     = "X-Gitlab-Event-UUID"
+	GitLabTokenHeader        = "X-Gitlab-Token"
+	GiteaEventHeader         = "X-Gitea-Event"
+	GiteaDeliveryHeader      = "X-Gitea-Delivery"
+	GiteaSignatureHeader     = "X-Gitea-Signature"
+	BitbucketEventHeader     = "X-Event-Key"
+	BitbucketDeliveryHeader  = "X-Request-UUID"
+	BitbucketSignatureHeader = "X-Hub-Signature"
+)
+
+// Valid reports whether the provider is known.
+func (p Provider) Valid() bool {
+	switch p {
+	case GitHub, GitLab, Gitea, Bitbucket:
+		return true
+	default:
+		return false
+	}
+}
+
+// ApplyAuth configures auth with the scheme the provider uses, leaving
+// any field already set untouched. Auth blocks combining validators are
+// left as is.
+func (p Provider) ApplyAuth(auth *Auth) {
+	if len(auth.All) > 0 || len(auth.Any) > 0 {
+		return
+	}
+
+	switch p {
+	case GitHub, Gitea, Bitbucket:
+		if auth.Validator == "" {
+			auth.Validator = HMACValidator
+		}
+
+		if auth.Validator == HMACValidator && auth.Header == "" {
+			auth.Header = map[Provider]string{
+				GitHub:    GitHubSignatureHeader,
+				Gitea:     GiteaSignatureHeader,
+				Bitbucket: BitbucketSignatureHeader,
+			}[p]
+		}
+
+	case GitLab:
+		if auth.Validator == "" {
+			auth.Validator = ListValidator
+		}
+
+		if auth.Validator == ListValidator && auth.Header == "" {
+			auth.Header = GitLabTokenHeader
+		}
+	}
+}
+
+// Event holds the normalized information of a webhook delivery.
+type Event struct {
+	Type       string
+	DeliveryID string
+	Ref        string
+	Repo       string
+	Commit     string
+}
+
+// Env returns the event as PIRATE_EVENT, PIRATE_DELIVERY_ID, PIRATE_REF,
+// PIRATE_REPO and PIRATE_COMMIT.
+func (e Event) Env() []string {
+	return []string{
+		"PIRATE_EVENT=" + e.Type,
+		"PIRATE_DELIVERY_ID=" + e.DeliveryID,
+		"PIRATE_REF=" + e.Ref,
+		"PIRATE_REPO=" + e.Repo,
+		"PIRATE_COMMIT=" + e.Commit,
+	}
+}
+
+// ParseEvent extracts the event from the headers and JSON body of a delivery.
+// Header fields are always set, the body ones only if the body could be parsed.
+func (p Provider) ParseEvent(header http.Header, body []byte) (Event, error) {
+	event := Event{}
+
+	switch p {
+	case GitHub:
+		event.Type, event.DeliveryID = header.Get(GitHubEventHeader), header.Get(GitHubDeliveryHeader)
+	case GitLab:
+		event.Type, event.DeliveryID = header.Get(GitLabEventHeader), header.Get(GitLabDeliveryHeader)
+	case Gitea:
+		event.Type, event.DeliveryID = header.Get(GiteaEventHeader), header.Get(GiteaDeliveryHeader)
+	case Bitbucket:
+		event.Type, event.DeliveryID = header.Get(BitbucketEventHeader), header.Get(BitbucketDeliveryHeader)
+	default:
+		return event, fmt.Errorf("unknown provider: '%s'", p)
+	}
+
+	payload := map[string]any{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return event, fmt.Errorf("could not parse %s payload: %w", p, err)
+	}
+
+	switch p {
+	case GitHub, Gitea:
+		event.Ref = firstOf(payload, "ref", "pull_request.head.ref")
+		event.Repo = firstOf(payload, "repository.full_name")
+		event.Commit = firstOf(payload, "after", "head_commit.id", "pull_request.head.sha")
+
+	case GitLab:
+		event.Ref = firstOf(payload, "ref", "object_attributes.source_branch")
+		event.Repo = firstOf(payload, "project.path_with_namespace")
+		event.Commit = firstOf(payload, "checkout_sha", "after", "object_attributes.last_commit.id")
+
+	case Bitbucket:
+		change, _ := lookupJSON(payload, "push.changes[0]")
+
+		event.Repo = firstOf(payload, "repository.full_name")
+		event.Commit = firstOf(change, "new.target.hash")
+
+		switch name := firstOf(change, "new.name"); firstOf(change, "new.type") {
+		case "branch":
+			event.Ref = "refs/heads/" + name
+		case "tag":
+			event.Ref = "refs/tags/" + name
+		}
+	}
+
+	return event, nil
+}
+
+// firstOf returns the first non-empty string found at one of the paths.
+func firstOf(payload any, paths ...string) string {
+	for _, path := range paths {
+		value, _ := lookupJSON(payload, path)
+		if str, ok := value.(string); ok && str != "" {
+			return str
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,159 @@
+package pirate
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestProviderLoadsAuthDefaults(t *testing.T) {
+	data := `
+server:
+  port: 3939
+  logging:
+    dir: ./logs
+handlers:
+  - endpoint: /github
+    name: github
+    provider: github
+    auth:
+      secret: 'my-secret'
+    run: echo
+  - endpoint: /gitlab
+    name: gitlab
+    provider: gitlab
+    auth:
+      token:
+        - 'my-token'
+    run: echo
+  - endpoint: /gitea
+    name: gitea
+    provider: gitea
+    auth:
+      secret: 'my-secret'
+      header: X-Custom-Signature
+    run: echo
+`
+
+	cfg, err := loadConfig(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("could not load config: %v", err)
+	}
+
+	if err := cfg.Valid(); err != nil {
+		t.Fatalf("expected config to be valid, got: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		validator ValidatorName
+		header    string
+	}{
+		{"github", HMACValidator, GitHubSignatureHeader},
+		{"gitlab", ListValidator, GitLabTokenHeader},
+		{"gitea", HMACValidator, "X-Custom-Signature"},
+	}
+
+	for k, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			auth := cfg.Handlers[k].Auth
+
+			if auth.Validator != test.validator {
+				tt.Fatalf("(validator) got '%s', want '%s'", auth.Validator, test.validator)
+			}
+
+			if auth.Header != test.header {
+				tt.Fatalf("(header) got '%s', want '%s'", auth.Header, test.header)
+			}
+		})
+	}
+
+	t.Run("unknown provider is invalid", func(tt *testing.T) {
+		cfgCopy := clone(cfg)
+		cfgCopy.Handlers = append([]Handler{}, cfg.Handlers...)
+		cfgCopy.Handlers[0].Provider = "sourcehut"
+
+		if err := cfgCopy.Valid(); err == nil {
+			tt.Fatalf("expected an error")
+		}
+	})
+}
+
+func TestProviderParseEvent(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider Provider
+		header   map[string]string
+		body     string
+		want     Event
+	}{
+		{
+			name:     "github push",
+			provider: GitHub,
+			header:   map[string]string{GitHubEventHeader: "push", GitHubDeliveryHeader: "abc-1"},
+			body:     `{"ref":"refs/heads/main","after":"deadbeef","repository":{"full_name":"owner/repo"}}`,
+			want:     Event{Type: "push", DeliveryID: "abc-1", Ref: "refs/heads/main", Repo: "owner/repo", Commit: "deadbeef"},
+		},
+		{
+			name:     "github pull request",
+			provider: GitHub,
+			header:   map[string]string{GitHubEventHeader: "pull_request"},
+			body:     `{"pull_request":{"head":{"ref":"feature","sha":"cafe"}},"repository":{"full_name":"owner/repo"}}`,
+			want:     Event{Type: "pull_request", Ref: "feature", Repo: "owner/repo", Commit: "cafe"},
+		},
+		{
+			name:     "gitlab push",
+			provider: GitLab,
+			header:   map[string]string{GitLabEventHeader: "Push Hook", GitLabDeliveryHeader: "abc-2"},
+			body:     `{"ref":"refs/heads/main","checkout_sha":"deadbeef","project":{"path_with_namespace":"group/repo"}}`,
+			want:     Event{Type: "Push Hook", DeliveryID: "abc-2", Ref: "refs/heads/main", Repo: "group/repo", Commit: "deadbeef"},
+		},
+		{
+			name:     "gitea push",
+			provider: Gitea,
+			header:   map[string]string{GiteaEventHeader: "push", GiteaDeliveryHeader: "abc-3"},
+			body:     `{"ref":"refs/tags/v1.0.0","after":"deadbeef","repository":{"full_name":"owner/repo"}}`,
+			want:     Event{Type: "push", DeliveryID: "abc-3", Ref: "refs/tags/v1.0.0", Repo: "owner/repo", Commit: "deadbeef"},
+		},
+		{
+			name:     "bitbucket push",
+			provider: Bitbucket,
+			header:   map[string]string{BitbucketEventHeader: "repo:push", BitbucketDeliveryHeader: "abc-4"},
+			body: `{"repository":{"full_name":"owner/repo"},` +
+				`"push":{"changes":[{"new":{"type":"branch","name":"main","target":{"hash":"deadbeef"}}}]}}`,
+			want: Event{Type: "repo:push", DeliveryID: "abc-4", Ref: "refs/heads/main", Repo: "owner/repo", Commit: "deadbeef"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			header := http.Header{}
+			for key, value := range test.header {
+				header.Set(key, value)
+			}
+
+			got, err := test.provider.ParseEvent(header, []byte(test.body))
+			if err != nil {
+				tt.Fatalf("unexpected error: %v", err)
+			}
+
+			if got != test.want {
+				tt.Fatalf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+
+	t.Run("header fields are kept if the body is not JSON", func(tt *testing.T) {
+		header := http.Header{}
+		header.Set(GitHubEventHeader, "ping")
+
+		got, err := GitHub.ParseEvent(header, []byte("not json"))
+		if err == nil {
+			tt.Fatalf("expected an error")
+		}
+
+		if got.Type != "ping" {
+			tt.Fatalf("got '%s', want 'ping'", got.Type)
+		}
+	})
+}
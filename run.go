@@ -4,34 +4,163 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"math"
 	"os"
 	"os/exec"
+	"os/user"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 )
 
-const tickInterval = 10 * time.Second
+const (
+	tickInterval = 10 * time.Second
 
-func runScript(ctx context.Context, fname, contents string, env []string, l *slog.Logger) error {
-	name, err := writeScript(l, fname, contents)
-	if err != nil {
-		return err
-	}
+	// prlimitPath is the util-linux program limits are applied with.
+	prlimitPath = "prlimit"
+
+	// killWaitDelay is how long to wait for the output of a killed script to be closed.
+	killWaitDelay = time.Second
+)
+
+// script describes a script to run.
+type script struct {
+	// pattern is used to name the temporary file the script is written to.
+	pattern  string
+	contents string
+	env      []string
+
+	// command is optional, if set it is run directly instead of writing contents
+	// to a temp file, after substituting ${VAR} with the variables of env.
+	command []string
+
+	// shell is the interpreter the script is run with, its path is appended to it.
+	// Defaults to bash.
+	shell []string
+
+	// dir is optional, the working directory of the script.
+	dir string
+
+	// tempDir is optional, the directory the script is written to instead of the default temp dir.
+	tempDir string
 
+	// killGracePeriod is how long the script has to exit once the context is done
+	// before its process group is sent SIGKILL. Defaults to defaultKillGracePeriod.
+	killGracePeriod time.Duration
+
+	// credential is optional, the user and groups the script runs as.
+	credential *syscall.Credential
+
+	// limits are applied to the script by running it through prlimit.
+	limits Limits
+
+	// stdin is optional, if set it is passed as the standard input of the script.
+	stdin io.Reader
+
+	// stdout is optional, if set the standard output is also written to it.
+	stdout io.Writer
+}
+
+// runScript runs the script, or its command if set.
+func runScript(ctx context.Context, spec script, l *slog.Logger) error { //nolint:funlen
 	runCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	defer func() { cleanupFile(l, name) }()
+	argv := expandArgs(spec.command, spec.env)
+
+	if len(argv) == 0 {
+		name, err := writeTempFile(l, spec.tempDir, spec.pattern, spec.contents)
+		if err != nil {
+			return err
+		}
+
+		defer func() { cleanupFile(l, name) }()
+
+		if spec.credential != nil {
+			if err := os.Chown(name, int(spec.credential.Uid), int(spec.credential.Gid)); err != nil {
+				l.Error("could not give the script to the user it runs as", "uid", spec.credential.Uid, "error", err)
+				return fmt.Errorf("could not chown script: %w", err)
+			}
+		}
+
+		shell := spec.shell
+		if len(shell) == 0 {
+			shell = []string{defaultShell}
+		}
+
+		argv = append(slices.Clone(shell), name)
+	}
+
+	if argv[0] == "" {
+		return errors.New("command is empty after substitution")
+	}
+
+	if spec.limits.Enabled() {
+		if err := checkLimits(runCtx, spec); err != nil {
+			l.Error("could not apply limits", "error", err)
+			return fmt.Errorf("could not apply limits: %w", err)
+		}
+
+		argv = append(spec.limits.prlimitArgs(), argv...)
+	}
+
+	cmd := exec.CommandContext(runCtx, argv[0], argv[1:]...) //nolint:gosec
+	cmd.Env = append(cmd.Env, spec.env...)
+	cmd.Stdin = spec.stdin
+	cmd.Dir = spec.dir
+
+	grace := spec.killGracePeriod
+	if grace <= 0 {
+		grace = defaultKillGracePeriod
+	}
+
+	// run the script in its own process group so the processes it starts are
+	// stopped along with it. Once stopped, the group is killed after the grace period
+	// even if the script exited already, since the processes it started may not have.
+	// cmd.Wait only returns once Cancel did, so killTimer can be read after it.
+	var killTimer *time.Timer
 
-	cmd := exec.CommandContext(runCtx, "bash", name)
-	cmd.Env = append(cmd.Env, env...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Credential: spec.credential}
+	cmd.Cancel = func() error {
+		l.Warn("stopping script", "reason", runCtx.Err(), "kill-grace-period", grace.String())
+
+		killTimer = time.AfterFunc(grace, func() {
+			if !groupExists(cmd.Process) {
+				return
+			}
+
+			if err := signalGroup(cmd.Process, syscall.SIGKILL); err == nil {
+				l.Warn("killed script after grace period", "kill-grace-period", grace.String())
+			}
+		})
+
+		return signalGroup(cmd.Process, syscall.SIGTERM)
+	}
+	cmd.WaitDelay = grace + killWaitDelay
 
 	stdout, stderr := newSafeBuffer(), newSafeBuffer()
 
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
 
+	if spec.stdout != nil {
+		cmd.Stdout = io.MultiWriter(stdout, spec.stdout)
+	}
+
 	if err := cmd.Start(); err != nil {
+		if spec.credential != nil {
+			l.Error(
+				"could not start script as user",
+				"uid", spec.credential.Uid,
+				"gid", spec.credential.Gid,
+				"error", err,
+			)
+		}
+
 		return fmt.Errorf("could not start command: %w", err)
 	}
 
@@ -48,7 +177,22 @@ func runScript(ctx context.Context, fname, contents string, env []string, l *slo
 		}
 	}()
 
-	if err := cmd.Wait(); err != nil {
+	err := cmd.Wait()
+
+	// the whole group exited within the grace period, nothing is left to kill.
+	if killTimer != nil && !groupExists(cmd.Process) {
+		killTimer.Stop()
+	}
+
+	// checked whether or not the script failed, as it may exit 0 once stopped.
+	if ctx.Err() != nil {
+		flush(stdout, stderr, l)
+		return fmt.Errorf("script stopped: %w", ctx.Err())
+	}
+
+	if err != nil {
+		flush(stdout, stderr, l)
+
 		code := 1
 
 		exitErr := &exec.ExitError{}
@@ -64,17 +208,214 @@ func runScript(ctx context.Context, fname, contents string, env []string, l *slo
 	return nil
 }
 
-func writeScript(l *slog.Logger, fname string, contents string) (string, error) {
-	fd, err := os.CreateTemp("", fname)
+// expandArgs replaces ${VAR} in args with the value of VAR in env, or an empty
+// string if unset. Values are never split, so each arg stays a single argument.
+func expandArgs(args []string, env []string) []string {
+	if len(args) == 0 {
+		return nil
+	}
+
+	values := make(map[string]string, len(env))
+
+	for _, kv := range env {
+		if key, value, ok := strings.Cut(kv, "="); ok {
+			values[key] = value
+		}
+	}
+
+	expanded := make([]string, 0, len(args))
+
+	for _, arg := range args {
+		var builder strings.Builder
+
+		for {
+			start := strings.Index(arg, "${")
+			if start == -1 {
+				break
+			}
+
+			end := strings.IndexByte(arg[start:], '}')
+			if end == -1 || !isEnvName(arg[start+2:start+end]) {
+				builder.WriteString(arg[:start+2])
+				arg = arg[start+2:]
+
+				continue
+			}
+
+			builder.WriteString(arg[:start])
+			builder.WriteString(values[arg[start+2:start+end]])
+			arg = arg[start+end+1:]
+		}
+
+		builder.WriteString(arg)
+		expanded = append(expanded, builder.String())
+	}
+
+	return expanded
+}
+
+// validScript checks exactly one of run and command is set.
+func validScript(label, run string, command []string) error {
+	hasRun, hasCommand := strings.TrimSpace(run) != "", len(command) > 0
+
+	switch {
+	case hasRun && hasCommand:
+		return ConflictingFieldsError{[]string{label + ".run", label + ".command"}}
+	case !hasRun && !hasCommand:
+		return fmt.Errorf("%s: one of 'run' or 'command' must be set", label)
+	case hasCommand && command[0] == "":
+		return MustBeSetError{label + ".command[0]"}
+	}
+
+	return nil
+}
+
+// lookupCredential resolves the user and group (names or ids) scripts run as. If only
+// userName is set, its primary group is used, and if only groupName is, the current
+// user. Supplementary groups are the ones of the user, if set. It returns nil if
+// neither is set.
+func lookupCredential(userName, groupName string) (*syscall.Credential, error) {
+	if userName == "" && groupName == "" {
+		return nil, nil //nolint:nilnil
+	}
+
+	credential := &syscall.Credential{
+		Uid:    uint32(os.Getuid()), //nolint:gosec
+		Gid:    uint32(os.Getgid()), //nolint:gosec
+		Groups: []uint32{},
+	}
+
+	if userName != "" {
+		usr, err := user.Lookup(userName)
+		if err != nil {
+			if usr, err = user.LookupId(userName); err != nil {
+				return nil, fmt.Errorf("unknown user '%s': %w", userName, err)
+			}
+		}
+
+		if credential.Uid, err = parseID(usr.Uid); err != nil {
+			return nil, err
+		}
+
+		if credential.Gid, err = parseID(usr.Gid); err != nil {
+			return nil, err
+		}
+
+		groupIDs, err := usr.GroupIds()
+		if err != nil {
+			return nil, fmt.Errorf("could not list groups of user '%s': %w", userName, err)
+		}
+
+		for _, groupID := range groupIDs {
+			gid, err := parseID(groupID)
+			if err != nil {
+				return nil, err
+			}
+
+			credential.Groups = append(credential.Groups, gid)
+		}
+	}
+
+	if groupName != "" {
+		grp, err := user.LookupGroup(groupName)
+		if err != nil {
+			if grp, err = user.LookupGroupId(groupName); err != nil {
+				return nil, fmt.Errorf("unknown group '%s': %w", groupName, err)
+			}
+		}
+
+		if credential.Gid, err = parseID(grp.Gid); err != nil {
+			return nil, err
+		}
+	}
+
+	return credential, nil
+}
+
+func parseID(id string) (uint32, error) {
+	value, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid id '%s': %w", id, err)
+	}
+
+	return uint32(value), nil
+}
+
+// prlimitArgs returns the prlimit (util-linux) invocation applying the limits to the
+// command following it, as both its soft and hard limits so they can't be raised.
+// prlimit runs as the user of the script, which can always lower its own limits.
+func (l Limits) prlimitArgs() []string {
+	args := []string{prlimitPath}
+
+	for _, limit := range []struct {
+		flag  string
+		value int64
+	}{
+		{"cpu", int64(math.Ceil(l.CPU.Seconds()))},
+		{"as", int64(l.Memory.Value)},
+		{"nofile", int64(l.OpenFiles)},
+		{"nproc", int64(l.Processes)},
+	} {
+		if limit.value > 0 {
+			args = append(args, fmt.Sprintf("--%s=%d", limit.flag, limit.value))
+		}
+	}
+
+	return append(args, "--")
+}
+
+// checkLimits runs true through prlimit with the limits of the script, as its user,
+// since once prlimit runs the script its failures can't be told apart from the script's.
+func checkLimits(ctx context.Context, spec script) error {
+	truePath, err := exec.LookPath("true")
+	if err != nil {
+		return fmt.Errorf("could not find true: %w", err)
+	}
+
+	argv := append(spec.limits.prlimitArgs(), truePath)
+
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...) //nolint:gosec
+	cmd.Env = []string{}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: spec.credential}
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+// signalGroup sends sig to the process group led by proc.
+func signalGroup(proc *os.Process, sig syscall.Signal) error {
+	if err := syscall.Kill(-proc.Pid, sig); err != nil {
+		if errors.Is(err, syscall.ESRCH) {
+			return os.ErrProcessDone
+		}
+
+		return fmt.Errorf("could not send %s: %w", sig, err)
+	}
+
+	return nil
+}
+
+// groupExists reports whether the process group led by proc still has members.
+func groupExists(proc *os.Process) bool {
+	return !errors.Is(signalGroup(proc, 0), os.ErrProcessDone)
+}
+
+// writeTempFile writes contents to a new temp file only readable by the current user,
+// in dir or the default temp dir if empty.
+func writeTempFile(l *slog.Logger, dir, fname string, contents string) (string, error) {
+	fd, err := os.CreateTemp(dir, fname)
 	if err != nil {
-		return "", fmt.Errorf("could not create script: %w", err)
+		return "", fmt.Errorf("could not create temp file: %w", err)
 	}
 	name := fd.Name()
 
 	wroteBytes, err := fd.WriteString(contents)
 	if err != nil {
 		cleanupFile(l, name)
-		return name, fmt.Errorf("error writing script: %w", err)
+		return name, fmt.Errorf("error writing temp file: %w", err)
 	}
 
 	wantBytes := len([]byte(contents))
@@ -0,0 +1,163 @@
+package pirate
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"math"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aalbacetef/pirate/scheduler"
+)
+
+func TestExpandArgs(t *testing.T) {
+	env := []string{"PIRATE_PARAM_ENV=prod", "PIRATE_BODY=$(rm -rf /) ${PIRATE_PARAM_ENV}", "EMPTY="}
+
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"deploy", "deploy"},
+		{"${PIRATE_PARAM_ENV}", "prod"},
+		{"--env=${PIRATE_PARAM_ENV}.${PIRATE_PARAM_ENV}", "--env=prod.prod"},
+		{"${PIRATE_BODY}", "$(rm -rf /) ${PIRATE_PARAM_ENV}"},
+		{"${UNSET}${EMPTY}", ""},
+		{"$PIRATE_PARAM_ENV", "$PIRATE_PARAM_ENV"},
+		{"${not valid}", "${not valid}"},
+		{"${PIRATE_PARAM_ENV", "${PIRATE_PARAM_ENV"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.arg, func(tt *testing.T) {
+			got := expandArgs([]string{test.arg}, env)
+			if !slices.Equal(got, []string{test.want}) {
+				tt.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLookupCredential(t *testing.T) {
+	if credential, err := lookupCredential("", ""); credential != nil || err != nil {
+		t.Fatalf("got %v, %v, want nil", credential, err)
+	}
+
+	for _, name := range []string{"root", "0"} {
+		credential, err := lookupCredential(name, name)
+		if err != nil {
+			t.Fatalf("(%s) unexpected error: %v", name, err)
+		}
+
+		if credential.Uid != 0 || credential.Gid != 0 {
+			t.Fatalf("(%s) got uid=%d gid=%d, want 0", name, credential.Uid, credential.Gid)
+		}
+	}
+
+	if _, err := lookupCredential("no-such-user-for-pirate", ""); err == nil {
+		t.Fatalf("error: should've failed for an unknown user")
+	}
+
+	if _, err := lookupCredential("", "no-such-group-for-pirate"); err == nil {
+		t.Fatalf("error: should've failed for an unknown group")
+	}
+}
+
+func TestRunScriptLimits(t *testing.T) {
+	if _, err := exec.LookPath(prlimitPath); err != nil {
+		t.Skip("prlimit is not installed")
+	}
+
+	spec := script{
+		pattern:  "pirate-test-*",
+		contents: `test "$(ulimit -n)" = 32`,
+		limits:   Limits{OpenFiles: 32},
+	}
+
+	if err := runScript(context.Background(), spec, slog.New(slog.NewJSONHandler(io.Discard, nil))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("scripts failing like prlimit are not mistaken for it", func(tt *testing.T) {
+		spec := spec
+		spec.contents = `echo "prlimit: failed" >&2; exit 1`
+
+		logs := &bytes.Buffer{}
+
+		err := runScript(context.Background(), spec, slog.New(slog.NewJSONHandler(logs, nil)))
+		if err == nil {
+			tt.Fatalf("error: should've failed")
+		}
+
+		if strings.Contains(logs.String(), "could not apply limits") {
+			tt.Fatalf("script failure reported as a limits failure: %s", logs.String())
+		}
+	})
+
+	t.Run("failing to apply limits is logged", func(tt *testing.T) {
+		// more than the kernel allows (fs.nr_open), even with CAP_SYS_RESOURCE.
+		spec.limits.OpenFiles = math.MaxInt32
+
+		logs := &bytes.Buffer{}
+
+		err := runScript(context.Background(), spec, slog.New(slog.NewJSONHandler(logs, nil)))
+		if err == nil {
+			tt.Fatalf("error: should've failed")
+		}
+
+		if !strings.Contains(logs.String(), "could not apply limits") {
+			tt.Fatalf("expected a log entry, got: %s", logs.String())
+		}
+	})
+}
+
+func TestRunScriptTimeout(t *testing.T) {
+	pipeline, err := scheduler.NewPipeline("timeout")
+	if err != nil {
+		t.Fatalf("could not create pipeline: %v", err)
+	}
+
+	if err := pipeline.Start(); err != nil {
+		t.Fatalf("could not start pipeline: %v", err)
+	}
+
+	// the script exits 0 once stopped, it still has to be recorded as timed out.
+	job, err := scheduler.NewJob(func(runCtx context.Context) error {
+		ctx, cancel := context.WithTimeout(runCtx, 200*time.Millisecond)
+		defer cancel()
+
+		spec := script{
+			pattern:         "pirate-test-*",
+			contents:        "trap 'exit 0' TERM\nsleep 30 >/dev/null 2>&1 &\nwait\n",
+			killGracePeriod: 100 * time.Millisecond,
+		}
+
+		return runScript(ctx, spec, slog.New(slog.NewJSONHandler(io.Discard, nil)))
+	})
+	if err != nil {
+		t.Fatalf("could not create job: %v", err)
+	}
+
+	if err := pipeline.Add(job); err != nil {
+		t.Fatalf("could not add job: %v", err)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+
+	for time.Now().Before(deadline) {
+		switch state := job.GetState(); state {
+		case scheduler.TimedOut:
+			return
+		case scheduler.Done, scheduler.Failed:
+			t.Fatalf("got state '%s', want '%s'", state, scheduler.TimedOut)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	t.Fatalf("job did not finish, state '%s'", job.GetState())
+}
@@ -127,9 +127,5 @@ func (drop *Drop) execute(ctx context.Context, job *Job) {
 		Type: JobEnded,
 	}
 
-	job.SetState(Done)
-
-	if err != nil {
-		job.SetState(Failed)
-	}
+	job.SetState(endState(err))
 }
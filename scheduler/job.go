@@ -2,6 +2,7 @@ package scheduler
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -24,9 +25,23 @@ const (
 	Queued     JobState = "queued"
 	Running    JobState = "running"
 	Failed     JobState = "failed"
+	TimedOut   JobState = "timed-out"
 	Done       JobState = "done"
 )
 
+// endState is the state a job ends in given the error its fn returned, jobs
+// whose fn returned an error wrapping context.DeadlineExceeded are TimedOut.
+func endState(err error) JobState {
+	switch {
+	case err == nil:
+		return Done
+	case errors.Is(err, context.DeadlineExceeded):
+		return TimedOut
+	default:
+		return Failed
+	}
+}
+
 type JobFn func(context.Context) error
 
 func NewJob(fn JobFn) (*Job, error) {
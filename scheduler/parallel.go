@@ -98,9 +98,5 @@ func (parallel *Parallel) execute(ctx context.Context, job *Job) {
 		Type: JobEnded,
 	}
 
-	job.SetState(Done)
-
-	if err != nil {
-		job.SetState(Failed)
-	}
+	job.SetState(endState(err))
 }
@@ -127,10 +127,7 @@ func (pipeline *Pipeline) runNextJob(ctx context.Context) {
 func (pipeline *Pipeline) execute(ctx context.Context, job *Job) {
 	err := job.fn(ctx)
 
-	job.SetState(Done)
-	if err != nil {
-		job.SetState(Failed)
-	}
+	job.SetState(endState(err))
 
 	pipeline.eventCh <- Event{
 		Type: JobEnded,
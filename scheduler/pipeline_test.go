@@ -3,6 +3,7 @@ package scheduler
 import (
 	"context"
 	"errors"
+	"fmt"
 	"testing"
 	"time"
 )
@@ -160,3 +161,31 @@ func mustGetPipelineState(t *testing.T, pipeline *Pipeline) PipelineState {
 
 	return state
 }
+
+func TestPipelineTimedOutJob(t *testing.T) {
+	timedOutJob := mustCreateJob(t, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+
+		<-ctx.Done()
+
+		return fmt.Errorf("script stopped: %w", ctx.Err())
+	})
+
+	pipeline, err := NewPipeline("handler-1")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	if err := pipeline.Start(); err != nil {
+		t.Fatalf("could not start pipeline: %v", err)
+	}
+
+	if err := pipeline.Add(timedOutJob); err != nil {
+		t.Fatalf("could not add to pipeline: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	compareState(t, TimedOut, mustGetPipelineState(t, pipeline), timedOutJob.ID)
+}
@@ -0,0 +1,41 @@
+package pirate
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// compileSchema loads and compiles the JSON Schema file of a handler. Only local
+// files can be referenced ($ref) from it.
+func compileSchema(label, fpath string) (*jsonschema.Schema, error) {
+	if fpath == "" {
+		return nil, nil //nolint:nilnil // no schema is set.
+	}
+
+	schema, err := jsonschema.NewCompiler().Compile(fpath)
+	if err != nil {
+		return nil, fmt.Errorf("%s: could not compile schema: %w", label, err)
+	}
+
+	return schema, nil
+}
+
+// validateSchema checks the body against the schema, if any.
+func validateSchema(schema *jsonschema.Schema, body []byte) error {
+	if schema == nil {
+		return nil
+	}
+
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("body is not valid JSON: %w", err)
+	}
+
+	if err := schema.Validate(doc); err != nil {
+		return fmt.Errorf("body does not match schema: %w", err)
+	}
+
+	return nil
+}
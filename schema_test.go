@@ -0,0 +1,97 @@
+package pirate
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const releaseSchema = `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "type": "object",
+  "required": ["version", "artifacts"],
+  "properties": {
+    "version": {"type": "string", "pattern": "^v[0-9]+\\.[0-9]+\\.[0-9]+$"},
+    "artifacts": {"type": "array", "minItems": 1, "items": {"type": "string"}}
+  }
+}`
+
+func TestHandleRequestSchema(t *testing.T) {
+	dir := t.TempDir()
+	schemaFile := filepath.Join(dir, "release.json")
+
+	if err := os.WriteFile(schemaFile, []byte(releaseSchema), filePerms); err != nil {
+		t.Fatalf("could not write schema: %v", err)
+	}
+
+	cfg, err := loadConfig(strings.NewReader(fmt.Sprintf(`
+server:
+  port: 3939
+  logging:
+    dir: ':stdout:'
+handlers:
+  - endpoint: /release
+    name: release
+    auth:
+      validator: list
+      token: [alpha]
+    schema: %s
+    run: echo "releasing"
+`, schemaFile)))
+	if err != nil {
+		t.Fatalf("could not load config: %v", err)
+	}
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("could not initialize server: %v", err)
+	}
+
+	defer server.Close()
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"valid body", `{"version":"v1.2.3","artifacts":["pirate.tar.gz"]}`, http.StatusOK},
+		{"missing field", `{"version":"v1.2.3"}`, http.StatusBadRequest},
+		{"wrong format", `{"version":"latest","artifacts":["pirate.tar.gz"]}`, http.StatusBadRequest},
+		{"not JSON", `version=v1.2.3`, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/release", strings.NewReader(test.body))
+			req.Header.Set(TokenHeaderField, "alpha")
+
+			rec := httptest.NewRecorder()
+			server.HandleRequest(rec, req)
+
+			if rec.Code != test.want {
+				tt.Fatalf("got status %d, want %d", rec.Code, test.want)
+			}
+		})
+	}
+
+	t.Run("invalid schema files fail validation", func(tt *testing.T) {
+		invalidFile := filepath.Join(dir, "invalid.json")
+		if err := os.WriteFile(invalidFile, []byte(`{"type": 42}`), filePerms); err != nil {
+			tt.Fatalf("could not write schema: %v", err)
+		}
+
+		for _, fpath := range []string{invalidFile, filepath.Join(dir, "missing.json")} {
+			cfgCopy := clone(cfg)
+			cfgCopy.Handlers = append([]Handler{}, cfg.Handlers...)
+			cfgCopy.Handlers[0].Schema = fpath
+
+			if cfgCopy.Valid() == nil {
+				tt.Fatalf("(%s) error: should've failed", fpath)
+			}
+		}
+	})
+}
@@ -3,7 +3,6 @@ package pirate
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -14,6 +13,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/santhosh-tekuri/jsonschema/v6"
+
 	"github.com/aalbacetef/pirate/scheduler"
 )
 
@@ -25,6 +26,11 @@ type Server struct {
 	validationTimeout time.Duration
 	cleanup           []func()
 	schedulers        []Scheduler
+	validators        []Validator
+	filters           []filter
+	endpoints         []endpointPattern
+	extractors        []bodyExtractor
+	schemas           []*jsonschema.Schema
 }
 
 func (srv *Server) Close() {
@@ -40,6 +46,7 @@ func (srv *Server) Close() {
 const (
 	defaultValidationTimeout = 5 * time.Second
 	dirPerms                 = 0o744
+	scriptDirPerms           = 0o711
 	filePerms                = 0o644
 )
 
@@ -100,8 +107,101 @@ func NewServer(cfg Config) (*Server, error) {
 		})
 	}
 
+	validators := make([]Validator, 0, len(cfg.Handlers))
+	for k, handler := range cfg.Handlers {
+		validator, err := newValidator(ValidatorParams{
+			Handler: handler.Name,
+			Label:   fmt.Sprintf("handler[%d].auth", k),
+			Auth:    handler.Auth,
+			Logger:  srv.logger.With("handler", handler.Name),
+			User:    handler.User,
+			Group:   handler.Group,
+			Limits:  handler.Limits,
+		})
+		if err != nil {
+			return nil, fmt.Errorf(
+				"could not create validator(name=%s): %w",
+				handler.Name, err,
+			)
+		}
+
+		validators = append(validators, validator)
+	}
+
+	filters := make([]filter, 0, len(cfg.Handlers))
+	for k, handler := range cfg.Handlers {
+		f, err := newFilter(fmt.Sprintf("handler[%d].when", k), handler.When)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"could not create filter(name=%s): %w",
+				handler.Name, err,
+			)
+		}
+
+		filters = append(filters, f)
+	}
+
+	extractors := make([]bodyExtractor, 0, len(cfg.Handlers))
+	for k, handler := range cfg.Handlers {
+		extractor, err := newBodyExtractor(fmt.Sprintf("handler[%d].env-from-body", k), handler.EnvFromBody)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"could not create env-from-body(name=%s): %w",
+				handler.Name, err,
+			)
+		}
+
+		extractors = append(extractors, extractor)
+	}
+
+	schemas := make([]*jsonschema.Schema, 0, len(cfg.Handlers))
+	for k, handler := range cfg.Handlers {
+		schema, err := compileSchema(fmt.Sprintf("handler[%d].schema", k), handler.Schema)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"could not load schema(name=%s): %w",
+				handler.Name, err,
+			)
+		}
+
+		schemas = append(schemas, schema)
+	}
+
+	for _, handler := range cfg.Handlers {
+		if handler.ScriptDir == "" {
+			continue
+		}
+
+		// other users (see Handler.User) can reach the scripts, only readable by the
+		// user they run as, but can't list them.
+		if err := os.MkdirAll(handler.ScriptDir, scriptDirPerms); err != nil {
+			return nil, fmt.Errorf(
+				"could not create script-dir(name=%s): %w",
+				handler.Name, err,
+			)
+		}
+	}
+
+	endpoints := make([]endpointPattern, 0, len(cfg.Handlers))
+	for _, handler := range cfg.Handlers {
+		pattern, err := parseEndpoint(handler.Endpoint)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"could not parse endpoint(name=%s): %w",
+				handler.Name, err,
+			)
+		}
+
+		endpoints = append(endpoints, pattern)
+	}
+
 	srv.cleanup = cleanup
+	srv.endpoints = endpoints
+	srv.extractors = extractors
+	srv.schemas = schemas
 	srv.schedulers = schedulers
+	srv.validators = validators
+	srv.filters = filters
 
 	return srv, nil
 }
@@ -177,130 +277,298 @@ func initializeLogging(loggingDir string) (*os.File, func(), error) {
 
 var ErrHandlerNotFound = errors.New("no matching handler was found")
 
+// FindHandler returns the first handler of the endpoint, see FindHandlers.
 func (srv *Server) FindHandler(endpoint string) (Handler, error) {
-	for _, h := range srv.cfg.Handlers {
-		if h.Endpoint == endpoint {
-			return h, nil
+	handlers := srv.FindHandlers(endpoint)
+	if len(handlers) == 0 {
+		return Handler{}, ErrHandlerNotFound
+	}
+
+	return handlers[0], nil
+}
+
+// FindHandlers returns every handler whose endpoint pattern matches the (escaped)
+// path, in the order they are configured.
+func (srv *Server) FindHandlers(endpoint string) []Handler {
+	matches := srv.matchHandlers(endpoint)
+	handlers := make([]Handler, 0, len(matches))
+
+	for _, match := range matches {
+		handlers = append(handlers, srv.cfg.Handlers[match.index])
+	}
+
+	return handlers
+}
+
+// handlerMatch is a handler whose endpoint matched the request path, along with
+// the parameters captured from it.
+type handlerMatch struct {
+	index  int
+	params map[string]string
+}
+
+func (srv *Server) matchHandlers(escapedPath string) []handlerMatch {
+	matches := make([]handlerMatch, 0, 1)
+
+	for k, pattern := range srv.endpoints {
+		if params, ok := pattern.Match(escapedPath); ok {
+			matches = append(matches, handlerMatch{index: k, params: params})
 		}
 	}
 
-	return Handler{}, ErrHandlerNotFound
+	return matches
+}
+
+// handlerOutcome is what happened to a request passed to one of the handlers of its
+// endpoint, from the least to the most relevant to the sender.
+type handlerOutcome int
+
+const (
+	rejected handlerOutcome = iota
+	filtered
+	invalid
+	tooLarge
+	triggered
+)
+
+// status returns the status the request is answered with.
+func (o handlerOutcome) status(handler Handler) int {
+	switch o {
+	case triggered:
+		return http.StatusOK
+	case tooLarge:
+		return http.StatusRequestEntityTooLarge
+	case invalid:
+		return http.StatusBadRequest
+	case filtered:
+		if handler.FilteredStatus == 0 {
+			return defaultFilteredStatus
+		}
+
+		return handler.FilteredStatus
+	case rejected:
+		return http.StatusNotFound
+	default:
+		return http.StatusNotFound
+	}
 }
 
 // HandleRequest is the main entrypoint of the server. It will first check if the
-// request is a valid endpoint and passes auth checks. Then it will spin off a
-// goroutine that executes the actual task.
-// @TODO: queue multiple executions of the same endpoint.
+// request is a valid endpoint and method, answering subscription handshakes (see
+// Challenge) right away. Then the request is fanned out to every handler of
+// the endpoint: each one checks auth and its filters on its own and, if they pass,
+// spins off a goroutine that executes the actual task.
+// The request is answered with 200 if any handler was triggered, 413 if the body is
+// over the limit of a handler, 400 if a handler found the body invalid, with the
+// filtered status of the first handler that filtered it, and 404 otherwise, in that order.
 func (srv *Server) HandleRequest(w http.ResponseWriter, req *http.Request) {
 	logger := srv.logger.With("Fn", "Server.HandleRequest", "req.URL.Path", req.URL.Path)
 
 	logger.Debug("checking matching handler...")
 
-	handler, err := srv.FindHandler(req.URL.Path)
-	if errors.Is(err, ErrHandlerNotFound) {
+	matches := srv.matchHandlers(req.URL.EscapedPath())
+	if len(matches) == 0 {
 		logger.Debug("no matching handler, returning 404")
 
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	if err != nil {
-		logger.Error("srv.FindHandler returned an unexpected error", "error", err)
+	// a method no handler accepts is answered like an unknown endpoint, to not leak
+	// which endpoints exist.
+	matches = srv.filterMethod(matches, req.Method)
+	if len(matches) == 0 {
+		logger.Debug("method not accepted, returning 404", "method", req.Method)
 
-		// no reason to let strangers know an error occurred.
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
+	// the body is read before validating as some validators (e.g: hmac) sign it.
+	// handlers with a lower limit than the largest one reject it in dispatch.
+	limit := int64(0)
+	for _, match := range matches {
+		limit = max(limit, srv.maxBodyBytes(srv.cfg.Handlers[match.index]))
+	}
+
+	payload, err := readBody(req, limit)
+	req.Body.Close()
+
+	switch {
+	case errors.Is(err, ErrBodyTooLarge):
+		logger.Info("request body too large", "limit", limit)
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+
+		return
+
+	case errors.Is(err, ErrUnsupportedEncoding):
+		logger.Info("unsupported request body encoding", "error", err)
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+
+		return
+
+	case err != nil:
+		logger.Info("error reading the request body", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	// handshakes are answered right away and don't trigger the handlers.
+	for _, match := range matches {
+		handler := srv.cfg.Handlers[match.index]
+		if handler.Challenge.Type == "" {
+			continue
+		}
+
+		if reply, ok := srv.answerChallenge(req, match, payload, logger.With("handler", handler.Name)); ok {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			w.WriteHeader(http.StatusOK)
+
+			if _, err := io.WriteString(w, string(reply)); err != nil {
+				logger.Error("could not write challenge reply", "error", err)
+			}
+
+			return
+		}
+	}
+
+	// no reason to let strangers know the endpoint is valid.
+	status, outcome := http.StatusNotFound, rejected
+
+	for _, match := range matches {
+		handler := srv.cfg.Handlers[match.index]
+
+		// the first handler with the most relevant outcome sets the status.
+		if got := srv.dispatch(req, match, payload, logger.With("handler", handler.Name)); got > outcome {
+			status, outcome = got.status(handler), got
+		}
+	}
+
+	w.WriteHeader(status)
+}
+
+// filterMethod keeps the matches whose handler accepts the method.
+func (srv *Server) filterMethod(matches []handlerMatch, method string) []handlerMatch {
+	accepted := make([]handlerMatch, 0, len(matches))
+
+	for _, match := range matches {
+		if srv.cfg.Handlers[match.index].acceptsMethod(method) {
+			accepted = append(accepted, match)
+		}
+	}
+
+	return accepted
+}
+
+// dispatch checks the request against the auth and filters of the handler and, if
+// it passes, kicks off the task.
+func (srv *Server) dispatch(req *http.Request, match handlerMatch, payload []byte, logger *slog.Logger) handlerOutcome {
+	index, handler := match.index, srv.cfg.Handlers[match.index]
+
+	if limit := srv.maxBodyBytes(handler); int64(len(payload)) > limit {
+		logger.Info("request body too large", "limit", limit)
+		return tooLarge
+	}
+
 	ctx, cancel := context.WithTimeout(req.Context(), srv.validationTimeout)
 	defer cancel()
 
-	if validationErr := validateRequest(ctx, srv.logger, handler.Name, handler.Auth, req); validationErr != nil {
-		// no reason to let strangers know the endpoint is valid.
-		w.WriteHeader(http.StatusNotFound)
+	ctx, validatorEnv := withValidatorEnv(ctx)
 
+	if validationErr := srv.validators[index].Validate(ctx, req, payload); validationErr != nil {
 		if errors.Is(validationErr, ErrAuthFailed) {
 			logger.Debug("authentication failed")
-			return
+			return rejected
 		}
 
 		logger.Error("unexpected request validation error", "error", validationErr)
 
-		return
+		return rejected
 	}
 
-	// kick off task and return.
-	payload, err := io.ReadAll(req.Body)
-	if err != nil {
-		srv.logger.Error("error reading the request body", "error", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	if matched, reason := srv.filters[index].Match(req, payload); !matched {
+		logger.Info("filtered", "condition", reason)
+		return filtered
+	}
 
-		return
+	if err := validateSchema(srv.schemas[index], payload); err != nil {
+		logger.Info("invalid body", "error", err)
+		return invalid
 	}
 
-	req.Body.Close()
+	fromBody, err := srv.extractors[index].Env(payload)
+	if err != nil {
+		logger.Info("invalid body", "error", err)
+		return invalid
+	}
+
+	extraEnv := validatorEnv.Vars()
+	extraEnv = append(extraEnv, fromBody...)
+	extraEnv = append(extraEnv, prefixedEnv(paramEnvPrefix, match.params)...)
 
-	headers := make(map[string]string, len(req.Header))
-	for key := range req.Header {
-		headers[key] = req.Header.Get(key)
+	query, err := queryEnv(req)
+	if err != nil {
+		logger.Error("could not expose query", "error", err)
 	}
 
-	// we don't pass the context as Do should run in the background independent of the request.
-	go srv.Do(&handler, headers, payload)
+	form, err := formEnv(req.Header, payload)
+	if err != nil {
+		logger.Warn("could not expose form", "error", err)
+	}
 
-	w.WriteHeader(http.StatusOK)
-}
+	extraEnv = append(extraEnv, query...)
+	extraEnv = append(extraEnv, form...)
 
-var (
-	ErrAuthFailed       = errors.New("authentication failed")
-	ErrUnknownValidator = errors.New("unknown validator")
-)
+	if handler.Provider != "" {
+		event, err := handler.Provider.ParseEvent(req.Header, payload)
+		if err != nil {
+			logger.Warn("could not parse provider event", "provider", handler.Provider, "error", err)
+		}
 
-const TokenHeaderField = "X-Authorization"
+		logger.Debug("parsed provider event", "event", event.Type, "delivery", event.DeliveryID)
 
-func validateRequest(ctx context.Context, logger *slog.Logger, name string, authCfg Auth, req *http.Request) error {
-	token := req.Header.Get(TokenHeaderField)
+		extraEnv = append(extraEnv, event.Env()...)
+	}
 
-	switch authCfg.Validator {
-	case ListValidator:
-		logger.Debug("using list validator")
+	// kick off task and return.
+	// we don't pass the context as Do should run in the background independent of the request.
+	go srv.Do(&handler, headerValues(req.Header), payload, extraEnv)
 
-		for _, tk := range authCfg.Token {
-			if token == tk {
-				return nil
-			}
-		}
+	return triggered
+}
 
-		return ErrAuthFailed
-
-	case CommandValidator:
-		if err := runScript(
-			ctx,
-			"pirate-command-*",
-			authCfg.Run,
-			[]string{
-				fmt.Sprintf("PIRATE_TOKEN='%s'", token),
-				fmt.Sprintf("PIRATE_NAME='%s'", name),
-			},
-			logger,
-		); err != nil {
-			return fmt.Errorf("command returned error: %w", err)
+// handlerIndex returns the index of the handler with the given name, or -1 if not found.
+func (srv *Server) handlerIndex(name string) int {
+	for k, h := range srv.cfg.Handlers {
+		if h.Name == name {
+			return k
 		}
+	}
 
-		return nil
+	return -1
+}
 
+// handlerTimeout returns how long the handler's script can run for, falling back to
+// the server's default and then defaultHandlerTimeout for configs not built by loadConfig.
+func (srv *Server) handlerTimeout(handler Handler) time.Duration {
+	switch {
+	case handler.Timeout.Duration > 0:
+		return handler.Timeout.Duration
+	case srv.cfg.Server.HandlerTimeout.Duration > 0:
+		return srv.cfg.Server.HandlerTimeout.Duration
 	default:
-		return ErrUnknownValidator
+		return defaultHandlerTimeout
 	}
 }
 
-const DoTimeout = 5 * time.Minute
-
-// Do runs after a request has been validated.
+// Do runs after a request has been validated, passing the payload as set by the
+// handler's Body. headers holds the request headers, with multiple values joined
+// (see headerValues), and extraEnv the variables set by the handler's validators
+// and the request.
 // @TODO: maybe enforce Content-Type: application/json ?
-// @TODO: add optional shell setting to config.
-// @TODO: add handler timeout setting.
-func (srv *Server) Do(handler *Handler, headers map[string]string, payload []byte) {
+func (srv *Server) Do(handler *Handler, headers map[string]string, payload []byte, extraEnv []string) {
 	l := srv.logger.With(
 		"Fn", "srv.Do",
 		"handler", handler.Name,
@@ -308,26 +576,16 @@ func (srv *Server) Do(handler *Handler, headers map[string]string, payload []byt
 
 	l.Info("starting handler")
 
-	buf := &bytes.Buffer{}
-
-	if err := json.NewEncoder(buf).Encode(headers); err != nil {
-		l.Error("could not encode headers", "error", err)
+	env, err := headersEnv(headers)
+	if err != nil {
+		l.Error("could not expose headers", "error", err)
 		return
 	}
 
-	env := []string{
-		fmt.Sprintf("PIRATE_HEADERS=%s", buf.String()),
-		fmt.Sprintf("PIRATE_BODY='%s'", string(payload)),
-	}
-
-	index := -1
-	for k, h := range srv.cfg.Handlers {
-		if h.Name == handler.Name {
-			index = k
-			break
-		}
-	}
+	// pirate's variables come last so they win over the static ones.
+	env = append(staticEnv(handler.Env, handler.EnvPassthrough), append(env, extraEnv...)...)
 
+	index := srv.handlerIndex(handler.Name)
 	if index == -1 {
 		l.Error("could not find matching scheduler", "handler.Name", handler.Name)
 		return
@@ -336,11 +594,64 @@ func (srv *Server) Do(handler *Handler, headers map[string]string, payload []byt
 	sched := srv.schedulers[index]
 
 	job, err := scheduler.NewJob(func(runCtx context.Context) error {
-		ctx, cancel := context.WithTimeout(runCtx, DoTimeout)
+		ctx, cancel := context.WithTimeout(runCtx, srv.handlerTimeout(*handler))
 		defer cancel()
 
-		if err := runScript(ctx, "pirate-webhook-script-*", handler.Run, env, l); err != nil {
-			l.Error("error running script", "error", err)
+		credential, err := lookupCredential(handler.User, handler.Group)
+		if err != nil {
+			l.Error("could not look up the user to run the script as", "error", err)
+			return err
+		}
+
+		spec := script{
+			pattern:  "pirate-webhook-script-*",
+			contents: handler.Run,
+			command:  handler.Command,
+			env:      env,
+			shell:    handler.ShellArgs(),
+			dir:      handler.Workdir,
+			tempDir:  handler.ScriptDir,
+
+			killGracePeriod: handler.KillGracePeriod.Duration,
+			credential:      credential,
+			limits:          handler.Limits,
+		}
+
+		switch handler.Body {
+		case BodyStdin:
+			spec.stdin = bytes.NewReader(payload)
+
+		case BodyFile:
+			// written once the job runs so queued or dropped jobs don't leave files behind.
+			name, err := writeTempFile(l, handler.ScriptDir, "pirate-webhook-body-*", string(payload))
+			if err != nil {
+				l.Error("could not write body file", "error", err)
+				return err
+			}
+
+			defer cleanupFile(l, name)
+
+			if credential != nil {
+				if err := os.Chown(name, int(credential.Uid), int(credential.Gid)); err != nil {
+					l.Error("could not give the body file to the user the script runs as", "error", err)
+					return fmt.Errorf("could not chown body file: %w", err)
+				}
+			}
+
+			spec.env = append(spec.env, "PIRATE_BODY_FILE="+name)
+
+		case BodyEnv, "":
+			spec.env = append(spec.env, "PIRATE_BODY="+string(payload))
+		}
+
+		if err := runScript(ctx, spec, l); err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				l.Error("script timed out", "timeout", srv.handlerTimeout(*handler).String(), "error", err)
+			} else {
+				l.Error("error running script", "error", err)
+			}
+
+			return err
 		}
 
 		return nil
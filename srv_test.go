@@ -3,8 +3,20 @@ package pirate
 import (
 	"bytes"
 	_ "embed"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 //go:embed testdata/ship.stdout.yml
@@ -102,3 +114,592 @@ func TestServerCleanup(t *testing.T) {
 		})
 	})
 }
+
+func TestHandleRequestFanOut(t *testing.T) {
+	dir := t.TempDir()
+
+	data := fmt.Sprintf(`
+server:
+  port: 3939
+  logging:
+    dir: ':stdout:'
+handlers:
+  - endpoint: /webhooks/repo
+    name: deploy
+    policy: queue
+    auth:
+      validator: list
+      token: [alpha]
+    run: touch %[1]s/deploy
+  - endpoint: /webhooks/repo
+    name: notify
+    policy: parallel
+    auth:
+      validator: list
+      token: [alpha]
+    run: touch %[1]s/notify
+  - endpoint: /webhooks/repo
+    name: filtered
+    auth:
+      validator: list
+      token: [alpha]
+    when:
+      - header: X-Event
+        equals: release
+    run: touch %[1]s/filtered
+  - endpoint: /webhooks/repo
+    name: other token
+    auth:
+      validator: list
+      token: [beta]
+    run: touch %[1]s/other
+`, dir)
+
+	cfg, err := loadConfig(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("could not load config: %v", err)
+	}
+
+	if err := cfg.Valid(); err != nil {
+		t.Fatalf("expected config to be valid, got: %v", err)
+	}
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("could not initialize server: %v", err)
+	}
+
+	defer server.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/repo", strings.NewReader("{}"))
+	req.Header.Set(TokenHeaderField, "alpha")
+	req.Header.Set("X-Event", "push")
+
+	rec := httptest.NewRecorder()
+	server.HandleRequest(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	for _, name := range []string{"deploy", "notify"} {
+		waitForFile(t, filepath.Join(dir, name))
+	}
+
+	for _, name := range []string{"filtered", "other"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			t.Fatalf("handler '%s' should not have run", name)
+		}
+	}
+}
+
+func waitForFile(t *testing.T, fpath string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(fpath); err == nil {
+			return
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	t.Fatalf("timed out waiting for '%s'", fpath)
+}
+
+func TestDoHeadersEnv(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/env", strings.NewReader("{}"))
+	req.Header.Set(TokenHeaderField, "alpha")
+	req.Header.Set("Some-Param", "some value")
+	req.Header.Add("X-Multi", "first")
+	req.Header.Add("X-Multi", "second")
+	req.Header.Set("X-Large", strings.Repeat("a", maxHeaderEnvBytes+1))
+
+	env := scriptEnv(t, nil, req)
+
+	tests := []struct {
+		name  string
+		key   string
+		want  string
+		isSet bool
+	}{
+		{"header name is normalized", "PIRATE_HEADERS_SOME_PARAM", "some value", true},
+		{"multiple values are joined", "PIRATE_HEADERS_X_MULTI", "first, second", true},
+		{"large headers are not exposed", "PIRATE_HEADERS_X_LARGE", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			got, ok := env[test.key]
+			if ok != test.isSet || got != test.want {
+				tt.Fatalf("got '%s' (set: %v), want '%s' (set: %v)", got, ok, test.want, test.isSet)
+			}
+		})
+	}
+
+	t.Run("all headers are available as JSON", func(tt *testing.T) {
+		headers := map[string]string{}
+		if err := json.Unmarshal([]byte(env["PIRATE_HEADERS"]), &headers); err != nil {
+			tt.Fatalf("could not decode PIRATE_HEADERS: %v", err)
+		}
+
+		if got := headers["X-Multi"]; got != "first, second" {
+			tt.Fatalf("got '%s', want 'first, second'", got)
+		}
+
+		if got := len(headers["X-Large"]); got != maxHeaderEnvBytes+1 {
+			tt.Fatalf("got %d bytes, want %d", got, maxHeaderEnvBytes+1)
+		}
+	})
+}
+
+// scriptEnv sends the request to a handler on /env, authenticated with the alpha
+// token, and returns the environment its script ran with. configure can change
+// the handler before the server is created.
+func scriptEnv(t *testing.T, configure func(*Handler), req *http.Request) map[string]string {
+	t.Helper()
+
+	out := filepath.Join(t.TempDir(), "env")
+
+	data := fmt.Sprintf(`
+server:
+  port: 3939
+  logging:
+    dir: ':stdout:'
+handlers:
+  - endpoint: /env
+    name: env
+    auth:
+      validator: list
+      token: [alpha]
+    run: env -0 > %[1]s.tmp && mv %[1]s.tmp %[1]s
+`, out)
+
+	cfg, err := loadConfig(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("could not load config: %v", err)
+	}
+
+	if configure != nil {
+		configure(&cfg.Handlers[0])
+	}
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("could not initialize server: %v", err)
+	}
+
+	defer server.Close()
+
+	rec := httptest.NewRecorder()
+	server.HandleRequest(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	waitForFile(t, out)
+
+	raw, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("could not read env: %v", err)
+	}
+
+	env := map[string]string{}
+
+	for _, kv := range strings.Split(string(raw), "\x00") {
+		if key, value, ok := strings.Cut(kv, "="); ok {
+			env[key] = value
+		}
+	}
+
+	return env
+}
+
+func TestDoBodyDelivery(t *testing.T) {
+	const body = `{"message": "it's a \"quoted\" body"}`
+
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/env", strings.NewReader(body))
+		req.Header.Set(TokenHeaderField, "alpha")
+
+		return req
+	}
+
+	t.Run("env", func(tt *testing.T) {
+		env := scriptEnv(tt, nil, newRequest())
+
+		if got := env["PIRATE_BODY"]; got != body {
+			tt.Fatalf("got '%s', want '%s'", got, body)
+		}
+	})
+
+	t.Run("stdin", func(tt *testing.T) {
+		env := scriptEnv(tt, func(h *Handler) {
+			h.Body = BodyStdin
+			h.Run = `STDIN_BODY="$(cat)" ` + h.Run
+		}, newRequest())
+
+		if got := env["STDIN_BODY"]; got != body {
+			tt.Fatalf("got '%s', want '%s'", got, body)
+		}
+
+		if _, ok := env["PIRATE_BODY"]; ok {
+			tt.Fatalf("PIRATE_BODY should not be set")
+		}
+	})
+
+	t.Run("file", func(tt *testing.T) {
+		env := scriptEnv(tt, func(h *Handler) {
+			h.Body = BodyFile
+			h.Run = `FILE_BODY="$(cat "$PIRATE_BODY_FILE")" ` + h.Run
+		}, newRequest())
+
+		if got := env["FILE_BODY"]; got != body {
+			tt.Fatalf("got '%s', want '%s'", got, body)
+		}
+
+		fpath := env["PIRATE_BODY_FILE"]
+		deadline := time.Now().Add(5 * time.Second)
+
+		for time.Now().Before(deadline) {
+			if _, err := os.Stat(fpath); errors.Is(err, os.ErrNotExist) {
+				return
+			}
+
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		tt.Fatalf("body file '%s' was not cleaned up", fpath)
+	})
+}
+
+func TestDoLargeForm(t *testing.T) {
+	form := url.Values{
+		"text": {strings.Repeat("a", 200*1024)},
+		"user": {"alice"},
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/env", strings.NewReader(form.Encode()))
+	req.Header.Set(TokenHeaderField, "alpha")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	env := scriptEnv(t, func(h *Handler) {
+		h.Body = BodyFile
+		h.Run = `FILE_SIZE="$(wc -c < "$PIRATE_BODY_FILE")" ` + h.Run
+	}, req)
+
+	if got, want := strings.TrimSpace(env["FILE_SIZE"]), strconv.Itoa(len(form.Encode())); got != want {
+		t.Fatalf("(body file size) got '%s', want '%s'", got, want)
+	}
+
+	if _, ok := env["PIRATE_FORM"]; ok {
+		t.Fatalf("PIRATE_FORM should not be set")
+	}
+
+	if _, ok := env["PIRATE_FORM_TEXT"]; ok {
+		t.Fatalf("PIRATE_FORM_TEXT should not be set")
+	}
+
+	if got := env["PIRATE_FORM_USER"]; got != "alice" {
+		t.Fatalf("(PIRATE_FORM_USER) got '%s', want 'alice'", got)
+	}
+}
+
+func TestDoShellAndWorkdir(t *testing.T) {
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/env", strings.NewReader("{}"))
+		req.Header.Set(TokenHeaderField, "alpha")
+
+		return req
+	}
+
+	t.Run("defaults to bash", func(tt *testing.T) {
+		env := scriptEnv(tt, func(h *Handler) {
+			h.Run = `SHELL_NAME="$BASH" ` + h.Run
+		}, newRequest())
+
+		if env["SHELL_NAME"] == "" {
+			tt.Fatalf("script was not run with bash")
+		}
+	})
+
+	t.Run("timeout falls back to the default if unset", func(tt *testing.T) {
+		env := scriptEnv(tt, func(h *Handler) {
+			h.Timeout = Duration{}
+			h.Run = `RAN=yes ` + h.Run
+		}, newRequest())
+
+		if env["RAN"] != "yes" {
+			tt.Fatalf("script did not run")
+		}
+	})
+
+	t.Run("shell with arguments", func(tt *testing.T) {
+		env := scriptEnv(tt, func(h *Handler) {
+			h.Shell = "sh -eu"
+			h.Run = `SHELL_FLAGS="$-" ` + h.Run
+		}, newRequest())
+
+		if got := env["SHELL_FLAGS"]; !strings.Contains(got, "e") || !strings.Contains(got, "u") {
+			tt.Fatalf("got flags '%s', want e and u to be set", got)
+		}
+	})
+
+	t.Run("python", func(tt *testing.T) {
+		if _, err := exec.LookPath("python3"); err != nil {
+			tt.Skip("python3 is not installed")
+		}
+
+		env := scriptEnv(tt, func(h *Handler) {
+			fields := strings.Fields(h.Run)
+			out := fields[len(fields)-1]
+			h.Shell = "python3"
+			h.Run = fmt.Sprintf(
+				"import os\nos.environ['FROM_PYTHON'] = 'yes'\n"+
+					"open(%[1]q + '.tmp', 'w').write('\\0'.join(k + '=' + v for k, v in os.environ.items()))\n"+
+					"os.rename(%[1]q + '.tmp', %[1]q)\n",
+				out,
+			)
+		}, newRequest())
+
+		if got := env["FROM_PYTHON"]; got != "yes" {
+			tt.Fatalf("got '%s', want 'yes'", got)
+		}
+	})
+
+	t.Run("workdir and script-dir", func(tt *testing.T) {
+		workdir := tt.TempDir()
+		scriptDir := filepath.Join(tt.TempDir(), "scripts")
+
+		env := scriptEnv(tt, func(h *Handler) {
+			h.Workdir = workdir
+			h.ScriptDir = scriptDir
+			h.Run = `SCRIPT_PATH="$0" ` + h.Run
+		}, newRequest())
+
+		if got := env["PWD"]; got != workdir {
+			tt.Fatalf("(PWD) got '%s', want '%s'", got, workdir)
+		}
+
+		if got := filepath.Dir(env["SCRIPT_PATH"]); got != scriptDir {
+			tt.Fatalf("(script dir) got '%s', want '%s'", got, scriptDir)
+		}
+	})
+}
+
+func TestDoTimeoutKillsProcessGroup(t *testing.T) {
+	// the subshells ignore SIGTERM, so only SIGKILL stops them.
+	tests := []struct {
+		name       string
+		background string
+	}{
+		{"holding the output open", `(trap '' TERM; sleep 30) &`},
+		{"with redirected output", `(trap '' TERM; sleep 30) >/dev/null 2>&1 &`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			pidFile := filepath.Join(tt.TempDir(), "pid")
+
+			data := fmt.Sprintf(`
+server:
+  port: 3939
+  logging:
+    dir: ':stdout:'
+handlers:
+  - endpoint: /slow
+    name: slow
+    timeout: 200ms
+    kill-grace-period: 200ms
+    auth:
+      validator: list
+      token: [alpha]
+    run: |
+      %[2]s
+      echo $! > %[1]s.tmp && mv %[1]s.tmp %[1]s
+      wait
+`, pidFile, test.background)
+
+			cfg, err := loadConfig(strings.NewReader(data))
+			if err != nil {
+				tt.Fatalf("could not load config: %v", err)
+			}
+
+			server, err := NewServer(cfg)
+			if err != nil {
+				tt.Fatalf("could not initialize server: %v", err)
+			}
+
+			defer server.Close()
+
+			req := httptest.NewRequest(http.MethodPost, "/slow", strings.NewReader("{}"))
+			req.Header.Set(TokenHeaderField, "alpha")
+
+			rec := httptest.NewRecorder()
+			server.HandleRequest(rec, req)
+
+			if rec.Code != http.StatusOK {
+				tt.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+			}
+
+			waitForFile(tt, pidFile)
+
+			raw, err := os.ReadFile(pidFile)
+			if err != nil {
+				tt.Fatalf("could not read pid: %v", err)
+			}
+
+			pid := strings.TrimSpace(string(raw))
+			deadline := time.Now().Add(3 * time.Second)
+
+			for time.Now().Before(deadline) {
+				// killed processes nobody reaps linger as zombies.
+				stat, err := os.ReadFile(filepath.Join("/proc", pid, "stat"))
+				if errors.Is(err, os.ErrNotExist) || (err == nil && strings.Contains(string(stat), ") Z ")) {
+					return
+				}
+
+				time.Sleep(50 * time.Millisecond)
+			}
+
+			tt.Fatalf("process %s started by the script is still running", pid)
+		})
+	}
+}
+
+func TestDoStaticEnv(t *testing.T) {
+	t.Setenv("PIRATE_TEST_SECRET", "s3cret")
+	t.Setenv("PIRATE_TEST_HIDDEN", "hidden")
+
+	req := httptest.NewRequest(http.MethodPost, "/env", strings.NewReader("{}"))
+	req.Header.Set(TokenHeaderField, "alpha")
+
+	env := scriptEnv(t, func(h *Handler) {
+		h.Env = map[string]string{"DEPLOY_ENV": "prod", "PIRATE_BODY": "overridden"}
+		h.EnvPassthrough = []string{"PIRATE_TEST_SECRET"}
+	}, req)
+
+	for key, want := range map[string]string{
+		"DEPLOY_ENV":         "prod",
+		"PIRATE_TEST_SECRET": "s3cret",
+		"PIRATE_BODY":        "{}",
+	} {
+		if got := env[key]; got != want {
+			t.Fatalf("(%s) got '%s', want '%s'", key, got, want)
+		}
+	}
+
+	if _, ok := env["PIRATE_TEST_HIDDEN"]; ok {
+		t.Fatalf("PIRATE_TEST_HIDDEN should not be passed through")
+	}
+}
+
+func TestDoCommand(t *testing.T) {
+	const body = `$(touch pwned); echo "quoted"`
+
+	req := httptest.NewRequest(http.MethodPost, "/env", strings.NewReader(body))
+	req.Header.Set(TokenHeaderField, "alpha")
+
+	workdir := t.TempDir()
+
+	env := scriptEnv(t, func(h *Handler) {
+		fields := strings.Fields(h.Run)
+		out := fields[len(fields)-1]
+
+		h.Run = ""
+		h.Workdir = workdir
+		h.Command = []string{
+			"sh", "-c", `BODY_ARG="$1" env -0 > "$2.tmp" && mv "$2.tmp" "$2"`,
+			"sh", "${PIRATE_BODY}", out,
+		}
+	}, req)
+
+	if got := env["BODY_ARG"]; got != body {
+		t.Fatalf("got '%s', want '%s'", got, body)
+	}
+
+	if _, err := os.Stat(filepath.Join(workdir, "pwned")); err == nil {
+		t.Fatalf("the body was run as a command")
+	}
+}
+
+func TestDoUserAndLimits(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("switching users requires root")
+	}
+
+	if _, err := user.Lookup("nobody"); err != nil {
+		t.Skip("user nobody does not exist")
+	}
+
+	// the script runs as nobody, so it must be able to reach and write the output,
+	// which it can't within t.TempDir.
+	dir, err := os.MkdirTemp("", "pirate-user-test-*")
+	if err != nil {
+		t.Fatalf("could not create dir: %v", err)
+	}
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	if err := os.Chmod(dir, 0o777); err != nil { //nolint:gosec
+		t.Fatalf("could not chmod dir: %v", err)
+	}
+
+	out := filepath.Join(dir, "out")
+
+	data := fmt.Sprintf(`
+server:
+  port: 3939
+  logging:
+    dir: ':stdout:'
+handlers:
+  - endpoint: /limited
+    name: limited
+    user: nobody
+    body: file
+    limits:
+      cpu: 1500ms
+      open-files: 64
+    auth:
+      validator: list
+      token: [alpha]
+    run: |
+      echo "$(id -un) $(ulimit -Hn) $(ulimit -t) $(cat "$PIRATE_BODY_FILE")" > %[1]s.tmp && mv %[1]s.tmp %[1]s
+`, out)
+
+	cfg, err := loadConfig(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("could not load config: %v", err)
+	}
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("could not initialize server: %v", err)
+	}
+
+	defer server.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/limited", strings.NewReader("{}"))
+	req.Header.Set(TokenHeaderField, "alpha")
+
+	rec := httptest.NewRecorder()
+	server.HandleRequest(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	waitForFile(t, out)
+
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("could not read output: %v", err)
+	}
+
+	if want := "nobody 64 2 {}"; strings.TrimSpace(string(got)) != want {
+		t.Fatalf("got '%s', want '%s'", strings.TrimSpace(string(got)), want)
+	}
+}
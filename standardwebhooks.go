@@ -0,0 +1,248 @@
+package pirate
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Standard Webhooks headers, see: https://www.standardwebhooks.com/
+const (
+	WebhookIDHeader        = "webhook-id"
+	WebhookTimestampHeader = "webhook-timestamp"
+	WebhookSignatureHeader = "webhook-signature"
+
+	webhookSecretPrefix    = "whsec_"
+	webhookSignatureScheme = "v1"
+)
+
+// standardWebhooksValidator verifies the request follows the Standard Webhooks spec:
+// the signature must match the HMAC-SHA256 of "id.timestamp.body", the timestamp
+// must be within the configured tolerance and the id must not have been seen before.
+type standardWebhooksValidator struct {
+	secret    []byte
+	tolerance time.Duration
+	store     *replayStore
+	logger    *slog.Logger
+
+	// now is overridden in tests.
+	now func() time.Time
+}
+
+func newStandardWebhooksValidator(params ValidatorParams) (Validator, error) { //nolint:ireturn
+	auth, label := params.Auth, params.Label
+
+	secret, err := decodeWebhookSecret(auth.Secret)
+	if err != nil {
+		return nil, MustBeSetError{label + ".secret"}
+	}
+
+	if auth.Tolerance.Duration <= 0 {
+		return nil, MustBeSetError{label + ".tolerance"}
+	}
+
+	store, err := newReplayStore(auth.ReplayFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &standardWebhooksValidator{
+		secret:    secret,
+		tolerance: auth.Tolerance.Duration,
+		store:     store,
+		logger:    params.Logger,
+		now:       time.Now,
+	}, nil
+}
+
+func (v *standardWebhooksValidator) Validate(_ context.Context, req *http.Request, body []byte) error {
+	v.logger.Debug("using standard-webhooks validator")
+
+	id := req.Header.Get(WebhookIDHeader)
+	timestamp := req.Header.Get(WebhookTimestampHeader)
+	signatures := req.Header.Get(WebhookSignatureHeader)
+
+	if id == "" || timestamp == "" || signatures == "" {
+		return ErrAuthFailed
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrAuthFailed
+	}
+
+	now := v.now()
+	sentAt := time.Unix(seconds, 0)
+
+	if now.Sub(sentAt) > v.tolerance || sentAt.Sub(now) > v.tolerance {
+		return ErrAuthFailed
+	}
+
+	mac := hmac.New(sha256.New, v.secret)
+	mac.Write([]byte(id + "." + timestamp + "."))
+	mac.Write(body)
+	want := mac.Sum(nil)
+
+	if !hasValidSignature(signatures, want) {
+		return ErrAuthFailed
+	}
+
+	// once the timestamp falls outside the tolerance window the request
+	// is rejected anyway, so there is no need to remember the id past it.
+	isNew, err := v.store.Add(id, sentAt.Add(v.tolerance), now)
+	if err != nil {
+		return fmt.Errorf("could not store webhook id: %w", err)
+	}
+
+	if !isNew {
+		return ErrAuthFailed
+	}
+
+	return nil
+}
+
+// hasValidSignature checks the space delimited list of "v1,<base64>" signatures
+// for one that matches want.
+func hasValidSignature(signatures string, want []byte) bool {
+	for _, entry := range strings.Fields(signatures) {
+		scheme, value, found := strings.Cut(entry, ",")
+		if !found || scheme != webhookSignatureScheme {
+			continue
+		}
+
+		got, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			continue
+		}
+
+		if hmac.Equal(got, want) {
+			return true
+		}
+	}
+
+	return false
+}
+
+var ErrInvalidWebhookSecret = errors.New("webhook secret must be base64 encoded, optionally prefixed by 'whsec_'")
+
+func decodeWebhookSecret(secret string) ([]byte, error) {
+	secret = strings.TrimPrefix(strings.TrimSpace(secret), webhookSecretPrefix)
+	if secret == "" {
+		return nil, ErrInvalidWebhookSecret
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, ErrInvalidWebhookSecret
+	}
+
+	return decoded, nil
+}
+
+// replayStore remembers the ids of the requests seen until they expire. If a
+// file is set, the ids are persisted to it so they survive restarts.
+type replayStore struct {
+	mu    sync.Mutex
+	fpath string
+	seen  map[string]time.Time
+}
+
+func newReplayStore(fpath string) (*replayStore, error) {
+	store := &replayStore{
+		fpath: fpath,
+		seen:  make(map[string]time.Time),
+	}
+
+	if fpath == "" {
+		return store, nil
+	}
+
+	data, err := os.ReadFile(fpath)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not read replay file (%s): %w", fpath, err)
+	}
+
+	if len(data) == 0 {
+		return store, nil
+	}
+
+	if err := json.Unmarshal(data, &store.seen); err != nil {
+		return nil, fmt.Errorf("could not parse replay file (%s): %w", fpath, err)
+	}
+
+	return store, nil
+}
+
+// Add records the id until expires and reports whether it wasn't already known.
+// Expired ids are pruned on every call.
+func (store *replayStore) Add(id string, expires, now time.Time) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for key, exp := range store.seen {
+		if now.After(exp) {
+			delete(store.seen, key)
+		}
+	}
+
+	if _, found := store.seen[id]; found {
+		return false, nil
+	}
+
+	store.seen[id] = expires
+
+	return true, store.persist()
+}
+
+// persist writes the store to a temporary file which is then renamed, so
+// a crash never leaves a partially written file behind.
+func (store *replayStore) persist() error {
+	if store.fpath == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(store.seen)
+	if err != nil {
+		return fmt.Errorf("could not encode replay store: %w", err)
+	}
+
+	fd, err := os.CreateTemp(filepath.Dir(store.fpath), filepath.Base(store.fpath)+".*")
+	if err != nil {
+		return fmt.Errorf("could not create replay file: %w", err)
+	}
+
+	tmpName := fd.Name()
+
+	if _, err := fd.Write(data); err != nil {
+		fd.Close()
+		os.Remove(tmpName)
+
+		return fmt.Errorf("could not write replay file: %w", err)
+	}
+
+	fd.Close()
+
+	if err := os.Rename(tmpName, store.fpath); err != nil {
+		os.Remove(tmpName)
+
+		return fmt.Errorf("could not replace replay file: %w", err)
+	}
+
+	return nil
+}
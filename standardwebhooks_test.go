@@ -0,0 +1,112 @@
+package pirate
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestValidateStandardWebhook(t *testing.T) {
+	secret := []byte("some-secret")
+	body := []byte(`{"type": "user.created"}`)
+	now := time.Now()
+
+	testLogger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	authCfg := Auth{
+		Validator:  StandardWebhooksValidator,
+		Secret:     "whsec_" + base64.StdEncoding.EncodeToString(secret),
+		Tolerance:  Duration{defaultWebhookTolerance},
+		ReplayFile: filepath.Join(t.TempDir(), "replay.json"),
+	}
+
+	newRequest := func(id string, sentAt time.Time) *http.Request {
+		timestamp := strconv.FormatInt(sentAt.Unix(), 10)
+
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(id + "." + timestamp + "." + string(body)))
+
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		req.Header.Set(WebhookIDHeader, id)
+		req.Header.Set(WebhookTimestampHeader, timestamp)
+		req.Header.Set(
+			WebhookSignatureHeader,
+			"v1,invalid v1,"+base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+		)
+
+		return req
+	}
+
+	newTestValidator := func(t *testing.T) *standardWebhooksValidator {
+		t.Helper()
+
+		validator, err := newStandardWebhooksValidator(ValidatorParams{Label: "auth", Auth: authCfg, Logger: testLogger})
+		if err != nil {
+			t.Fatalf("could not create validator: %v", err)
+		}
+
+		swv, ok := validator.(*standardWebhooksValidator)
+		if !ok {
+			t.Fatalf("unexpected validator type: %T", validator)
+		}
+
+		swv.now = func() time.Time { return now }
+
+		return swv
+	}
+
+	validator := newTestValidator(t)
+	ctx := context.Background()
+
+	t.Run("valid request passes", func(tt *testing.T) {
+		err := validator.Validate(ctx, newRequest("msg_1", now), body)
+		if err != nil {
+			tt.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("replayed request fails", func(tt *testing.T) {
+		err := validator.Validate(ctx, newRequest("msg_1", now), body)
+		if !errors.Is(err, ErrAuthFailed) {
+			tt.Fatalf("got '%v', want '%v'", err, ErrAuthFailed)
+		}
+	})
+
+	t.Run("replay protection survives restarts", func(tt *testing.T) {
+		reloaded := newTestValidator(tt)
+
+		err := reloaded.Validate(ctx, newRequest("msg_1", now), body)
+		if !errors.Is(err, ErrAuthFailed) {
+			tt.Fatalf("got '%v', want '%v'", err, ErrAuthFailed)
+		}
+	})
+
+	t.Run("timestamp outside tolerance fails", func(tt *testing.T) {
+		sentAt := now.Add(-2 * defaultWebhookTolerance)
+
+		err := validator.Validate(ctx, newRequest("msg_2", sentAt), body)
+		if !errors.Is(err, ErrAuthFailed) {
+			tt.Fatalf("got '%v', want '%v'", err, ErrAuthFailed)
+		}
+	})
+
+	t.Run("tampered body fails", func(tt *testing.T) {
+		req := newRequest("msg_3", now)
+
+		err := validator.Validate(ctx, req, []byte(`{}`))
+		if !errors.Is(err, ErrAuthFailed) {
+			tt.Fatalf("got '%v', want '%v'", err, ErrAuthFailed)
+		}
+	})
+}
@@ -105,7 +105,7 @@ file_should_eq ~/headers.json '{"Accept":"*/*","Content-Length":"20","Content-Ty
 log "ok"
 
 log "step: checking body.json matches what we expect..."
-file_should_eq ~/body.json "'{\"data\": [1, 2, 3] }'"
+file_should_eq ~/body.json '{"data": [1, 2, 3] }'
 log "ok"
 
 ##
@@ -0,0 +1,112 @@
+package pirate
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+)
+
+// TLSConfig builds the TLS config the server should use, loading the client
+// CA bundle if set. The certificate and key are loaded by the http.Server.
+func (t TLS) TLSConfig() (*tls.Config, error) {
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	switch t.ClientAuth {
+	case NoClientCert, "":
+		tlsCfg.ClientAuth = tls.NoClientCert
+	case RequestClientCert:
+		tlsCfg.ClientAuth = tls.RequestClientCert
+	case RequireAnyClientCert:
+		tlsCfg.ClientAuth = tls.RequireAnyClientCert
+	case VerifyClientCertIfGiven:
+		tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
+	case RequireAndVerifyClientCert:
+		tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("unknown client auth mode: '%s'", t.ClientAuth)
+	}
+
+	if t.ClientCA == "" {
+		return tlsCfg, nil
+	}
+
+	data, err := os.ReadFile(t.ClientCA)
+	if err != nil {
+		return nil, fmt.Errorf("could not read client CA bundle: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in client CA bundle (%s)", t.ClientCA)
+	}
+
+	tlsCfg.ClientCAs = pool
+
+	return tlsCfg, nil
+}
+
+// clientCertValidator passes if the verified client certificate has a common
+// name or subject alternative name in the allowlist. Only certificates verified
+// against the client CA bundle are considered.
+type clientCertValidator struct {
+	subjects []string
+	logger   *slog.Logger
+}
+
+func newClientCertValidator(params ValidatorParams) (Validator, error) { //nolint:ireturn
+	if len(params.Auth.Subjects) == 0 {
+		return nil, MustBeSetError{params.Label + ".subjects"}
+	}
+
+	return &clientCertValidator{
+		subjects: params.Auth.Subjects,
+		logger:   params.Logger,
+	}, nil
+}
+
+func (v *clientCertValidator) Validate(_ context.Context, req *http.Request, _ []byte) error {
+	v.logger.Debug("using client-cert validator")
+
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return ErrAuthFailed
+	}
+
+	cert := req.TLS.VerifiedChains[0][0]
+
+	for _, name := range certificateNames(cert) {
+		if slices.Contains(v.subjects, name) {
+			return nil
+		}
+	}
+
+	v.logger.Debug("client certificate not allowed", "subject", cert.Subject.String())
+
+	return ErrAuthFailed
+}
+
+// certificateNames returns the common name and subject alternative names of the certificate.
+func certificateNames(cert *x509.Certificate) []string {
+	names := make([]string, 0, 1+len(cert.DNSNames)+len(cert.EmailAddresses)+len(cert.IPAddresses)+len(cert.URIs))
+
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+
+	return names
+}
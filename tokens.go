@@ -0,0 +1,194 @@
+package pirate
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Prefixes of hashed tokens, anything else is considered a plaintext token.
+const (
+	sha256TokenPrefix = "sha256:"
+	bcryptTokenPrefix = "bcrypt:"
+	argon2TokenPrefix = "argon2:"
+)
+
+// tokenMatcher checks a token sent in a request against a configured one.
+type tokenMatcher interface {
+	Match(token []byte) bool
+}
+
+// plainToken compares tokens in constant time. Both sides are hashed first so
+// the comparison doesn't leak the length of the token either.
+type plainToken [sha256.Size]byte
+
+func (tk plainToken) Match(token []byte) bool {
+	sum := sha256.Sum256(token)
+	return subtle.ConstantTimeCompare(tk[:], sum[:]) == 1
+}
+
+type sha256Token []byte
+
+func (tk sha256Token) Match(token []byte) bool {
+	sum := sha256.Sum256(token)
+	return subtle.ConstantTimeCompare(tk, sum[:]) == 1
+}
+
+type bcryptToken []byte
+
+func (tk bcryptToken) Match(token []byte) bool {
+	return bcrypt.CompareHashAndPassword(tk, token) == nil
+}
+
+// argon2Token holds a hash in PHC format: $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>.
+type argon2Token struct {
+	variant string
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	hash    []byte
+}
+
+func (tk argon2Token) Match(token []byte) bool {
+	keyLen := uint32(len(tk.hash)) //nolint:gosec // hash length is bounded by the config.
+
+	var got []byte
+
+	switch tk.variant {
+	case "argon2i":
+		got = argon2.Key(token, tk.salt, tk.time, tk.memory, tk.threads, keyLen)
+	default:
+		got = argon2.IDKey(token, tk.salt, tk.time, tk.memory, tk.threads, keyLen)
+	}
+
+	return subtle.ConstantTimeCompare(tk.hash, got) == 1
+}
+
+var ErrInvalidArgon2Hash = errors.New("argon2 hash must be in the PHC format: $argon2id$v=19$m=<m>,t=<t>,p=<p>$<salt>$<hash>")
+
+func parseArgon2Token(value string) (argon2Token, error) {
+	parts := strings.Split(value, "$")
+
+	const wantParts = 6
+	if len(parts) != wantParts || parts[0] != "" {
+		return argon2Token{}, ErrInvalidArgon2Hash
+	}
+
+	tk := argon2Token{variant: parts[1]}
+
+	if tk.variant != "argon2id" && tk.variant != "argon2i" {
+		return argon2Token{}, ErrInvalidArgon2Hash
+	}
+
+	version := 0
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return argon2Token{}, ErrInvalidArgon2Hash
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &tk.memory, &tk.time, &tk.threads); err != nil {
+		return argon2Token{}, ErrInvalidArgon2Hash
+	}
+
+	var err error
+
+	if tk.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return argon2Token{}, ErrInvalidArgon2Hash
+	}
+
+	if tk.hash, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(tk.hash) == 0 {
+		return argon2Token{}, ErrInvalidArgon2Hash
+	}
+
+	return tk, nil
+}
+
+// parseToken parses a token entry, which is either in plaintext or a hash prefixed by its scheme.
+func parseToken(entry string) (tokenMatcher, error) { //nolint:ireturn
+	switch {
+	case strings.HasPrefix(entry, sha256TokenPrefix):
+		sum, err := hex.DecodeString(strings.TrimPrefix(entry, sha256TokenPrefix))
+		if err != nil || len(sum) != sha256.Size {
+			return nil, errors.New("sha256 token must be a hex encoded sha256 sum")
+		}
+
+		return sha256Token(sum), nil
+
+	case strings.HasPrefix(entry, bcryptTokenPrefix):
+		hash := []byte(strings.TrimPrefix(entry, bcryptTokenPrefix))
+		if _, err := bcrypt.Cost(hash); err != nil {
+			return nil, fmt.Errorf("invalid bcrypt hash: %w", err)
+		}
+
+		return bcryptToken(hash), nil
+
+	case strings.HasPrefix(entry, argon2TokenPrefix):
+		return parseArgon2Token(strings.TrimPrefix(entry, argon2TokenPrefix))
+
+	default:
+		return plainToken(sha256.Sum256([]byte(entry))), nil
+	}
+}
+
+// loadTokens gathers the token entries of the auth block, reading them from
+// TokenFile and TokenEnv if set.
+func loadTokens(label string, auth Auth) ([]string, error) {
+	entries := make([]string, 0, len(auth.Token))
+	entries = append(entries, auth.Token...)
+
+	if auth.TokenFile != "" {
+		fromFile, err := readTokenFile(auth.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s.token-file: %w", label, err)
+		}
+
+		entries = append(entries, fromFile...)
+	}
+
+	if auth.TokenEnv != "" {
+		value := strings.TrimSpace(os.Getenv(auth.TokenEnv))
+		if value == "" {
+			return nil, fmt.Errorf("%s.token-env: environment variable '%s' is not set", label, auth.TokenEnv)
+		}
+
+		entries = append(entries, value)
+	}
+
+	return entries, nil
+}
+
+// readTokenFile reads one token per line, blank lines and lines starting with # are ignored.
+func readTokenFile(fpath string) ([]string, error) {
+	data, err := os.ReadFile(fpath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read token file: %w", err)
+	}
+
+	entries := make([]string, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		entries = append(entries, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read token file: %w", err)
+	}
+
+	return entries, nil
+}
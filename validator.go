@@ -0,0 +1,265 @@
+package pirate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Validator authenticates an incoming request. The body has already been read
+// from the request and is passed in separately.
+// It should return ErrAuthFailed (or an error wrapping it) if the request is not
+// authenticated, any other error is logged as unexpected.
+type Validator interface {
+	Validate(ctx context.Context, req *http.Request, body []byte) error
+}
+
+// ValidatorFunc allows using a plain function as a Validator.
+type ValidatorFunc func(ctx context.Context, req *http.Request, body []byte) error
+
+func (fn ValidatorFunc) Validate(ctx context.Context, req *http.Request, body []byte) error {
+	return fn(ctx, req, body)
+}
+
+// ValidatorParams holds what a ValidatorFactory needs to build a Validator for a handler.
+type ValidatorParams struct {
+	// Handler is the name of the handler the auth block belongs to.
+	Handler string
+
+	// Label is the path of the auth block in the config (e.g: handler[0].auth),
+	// it should be used to report invalid fields.
+	Label string
+
+	Auth   Auth
+	Logger *slog.Logger
+
+	// User, Group and Limits are the ones of the handler, validators running
+	// scripts run them as the same user and with the same limits as the handler's.
+	User   string
+	Group  string
+	Limits Limits
+}
+
+// ValidatorFactory builds a Validator from its configuration. It is called when
+// the config is validated as well as when the server is created, so it should
+// report invalid settings as errors and not keep any resources open.
+type ValidatorFactory func(params ValidatorParams) (Validator, error)
+
+var (
+	ErrAuthFailed              = errors.New("authentication failed")
+	ErrUnknownValidator        = errors.New("unknown validator")
+	ErrValidatorAlreadyDefined = errors.New("validator already registered")
+)
+
+type validatorRegistry struct {
+	mu        sync.RWMutex
+	factories map[ValidatorName]ValidatorFactory
+}
+
+//nolint:gochecknoglobals // validators are registered before the config is loaded, like database/sql drivers.
+var registry = &validatorRegistry{
+	factories: map[ValidatorName]ValidatorFactory{
+		ListValidator:             newListValidator,
+		CommandValidator:          newCommandValidator,
+		HMACValidator:             newHMACValidator,
+		StandardWebhooksValidator: newStandardWebhooksValidator,
+		IPValidator:               newIPValidator,
+		JWTValidator:              newJWTValidator,
+		ClientCertValidator:       newClientCertValidator,
+	},
+}
+
+// RegisterValidator makes a validator available by name, so it can be referenced
+// from the config via `validator: <name>`. It should be called before the config is loaded.
+// Settings specific to the validator can be read from the auth block with Auth.Decode.
+func RegisterValidator(name ValidatorName, factory ValidatorFactory) error {
+	if strings.TrimSpace(string(name)) == "" {
+		return errors.New("validator name must not be empty")
+	}
+
+	if factory == nil {
+		return errors.New("validator factory must not be nil")
+	}
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if _, found := registry.factories[name]; found {
+		return fmt.Errorf("%w: '%s'", ErrValidatorAlreadyDefined, name)
+	}
+
+	registry.factories[name] = factory
+
+	return nil
+}
+
+// newValidator builds the validator referenced by params.Auth.
+func newValidator(params ValidatorParams) (Validator, error) { //nolint:ireturn
+	if params.Logger == nil {
+		params.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	if len(params.Auth.All) > 0 || len(params.Auth.Any) > 0 {
+		return newCompositeValidator(params)
+	}
+
+	name := params.Auth.Validator
+	if name == "" {
+		return nil, MustBeSetError{params.Label + ".validator"}
+	}
+
+	registry.mu.RLock()
+	factory, found := registry.factories[name]
+	registry.mu.RUnlock()
+
+	if !found {
+		return nil, fmt.Errorf("%s.validator: %w: '%s'", params.Label, ErrUnknownValidator, name)
+	}
+
+	return factory(params)
+}
+
+const TokenHeaderField = "X-Authorization"
+
+// listValidator passes if the token in the request header (X-Authorization
+// unless configured otherwise) matches one of the list.
+type listValidator struct {
+	tokens []tokenMatcher
+	header string
+	logger *slog.Logger
+}
+
+func newListValidator(params ValidatorParams) (Validator, error) { //nolint:ireturn
+	entries, err := loadTokens(params.Label, params.Auth)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entries) == 0 {
+		return nil, MustBeSetError{params.Label + ".tokens"}
+	}
+
+	tokens := make([]tokenMatcher, 0, len(entries))
+
+	for k, entry := range entries {
+		matcher, err := parseToken(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%s.token[%d]: %w", params.Label, k, err)
+		}
+
+		tokens = append(tokens, matcher)
+	}
+
+	header := params.Auth.Header
+	if header == "" {
+		header = TokenHeaderField
+	}
+
+	return &listValidator{tokens: tokens, header: header, logger: params.Logger}, nil
+}
+
+func (v *listValidator) Validate(_ context.Context, req *http.Request, _ []byte) error {
+	v.logger.Debug("using list validator")
+
+	token := []byte(req.Header.Get(v.header))
+	if len(token) == 0 {
+		return ErrAuthFailed
+	}
+
+	// every token is checked so the time taken doesn't depend on which one matched.
+	matched := false
+
+	for _, tk := range v.tokens {
+		if tk.Match(token) {
+			matched = true
+		}
+	}
+
+	if !matched {
+		return ErrAuthFailed
+	}
+
+	return nil
+}
+
+// commandValidator passes if its script exits with code 0. The script gets the
+// request metadata as env vars and the request body on stdin. If exportEnv is set,
+// KEY=VALUE lines printed to stdout are passed on to the handler's script. The script
+// runs as the handler's user and group, with its limits.
+type commandValidator struct {
+	name      string
+	run       string
+	command   []string
+	exportEnv bool
+	user      string
+	group     string
+	limits    Limits
+	logger    *slog.Logger
+}
+
+func newCommandValidator(params ValidatorParams) (Validator, error) { //nolint:ireturn
+	if err := validScript(params.Label, params.Auth.Run, params.Auth.Command); err != nil {
+		return nil, err
+	}
+
+	return &commandValidator{
+		name:      params.Handler,
+		run:       params.Auth.Run,
+		command:   params.Auth.Command,
+		exportEnv: params.Auth.ExportEnv,
+		user:      params.User,
+		group:     params.Group,
+		limits:    params.Limits,
+		logger:    params.Logger,
+	}, nil
+}
+
+func (v *commandValidator) Validate(ctx context.Context, req *http.Request, body []byte) error {
+	token := req.Header.Get(TokenHeaderField)
+
+	reqEnv, err := requestEnv(req)
+	if err != nil {
+		return err
+	}
+
+	credential, err := lookupCredential(v.user, v.group)
+	if err != nil {
+		return fmt.Errorf("could not look up the user to run the command as: %w", err)
+	}
+
+	env := []string{
+		"PIRATE_TOKEN=" + token,
+		"PIRATE_NAME=" + v.name,
+	}
+
+	stdout := &bytes.Buffer{}
+	spec := script{
+		pattern:    "pirate-command-*",
+		contents:   v.run,
+		command:    v.command,
+		env:        append(env, reqEnv...),
+		credential: credential,
+		limits:     v.limits,
+		stdin:      bytes.NewReader(body),
+		stdout:     stdout,
+	}
+
+	if err := runScript(ctx, spec, v.logger); err != nil {
+		return fmt.Errorf("command returned error: %w", err)
+	}
+
+	if v.exportEnv {
+		for _, kv := range parseEnvLines(stdout.String()) {
+			key, value, _ := strings.Cut(kv, "=")
+			SetEnv(ctx, key, value)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,559 @@
+package pirate
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const customValidatorConfig = `
+server:
+  port: 3939
+  logging:
+    dir: ':stdout:'
+
+handlers:
+  - endpoint: /custom
+    name: custom validator handler
+    auth:
+      validator: test-header-equals
+      header-name: X-Custom
+      header-value: expected
+    run: echo "ok"
+`
+
+func TestRegisterValidator(t *testing.T) {
+	type settings struct {
+		Name  string `yaml:"header-name"`
+		Value string `yaml:"header-value"`
+	}
+
+	err := RegisterValidator("test-header-equals", func(params ValidatorParams) (Validator, error) {
+		opts := settings{}
+		if err := params.Auth.Decode(&opts); err != nil {
+			return nil, err
+		}
+
+		if opts.Name == "" {
+			return nil, MustBeSetError{params.Label + ".header-name"}
+		}
+
+		return ValidatorFunc(func(_ context.Context, req *http.Request, _ []byte) error {
+			if req.Header.Get(opts.Name) != opts.Value {
+				return ErrAuthFailed
+			}
+
+			return nil
+		}), nil
+	})
+	if err != nil {
+		t.Fatalf("could not register validator: %v", err)
+	}
+
+	t.Run("registering twice fails", func(tt *testing.T) {
+		err := RegisterValidator(ListValidator, newListValidator)
+		if !errors.Is(err, ErrValidatorAlreadyDefined) {
+			tt.Fatalf("got '%v', want '%v'", err, ErrValidatorAlreadyDefined)
+		}
+	})
+
+	cfg, err := loadConfig(strings.NewReader(customValidatorConfig))
+	if err != nil {
+		t.Fatalf("could not load config: %v", err)
+	}
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("could not initialize server: %v", err)
+	}
+
+	defer server.Close()
+
+	t.Run("custom validator reads its settings", func(tt *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/custom", nil)
+		req.Header.Set("X-Custom", "expected")
+
+		if err := server.validators[0].Validate(context.Background(), req, nil); err != nil {
+			tt.Fatalf("unexpected error: %v", err)
+		}
+
+		req.Header.Set("X-Custom", "unexpected")
+
+		if err := server.validators[0].Validate(context.Background(), req, nil); !errors.Is(err, ErrAuthFailed) {
+			tt.Fatalf("got '%v', want '%v'", err, ErrAuthFailed)
+		}
+	})
+
+	t.Run("unknown validators fail the config", func(tt *testing.T) {
+		invalid := strings.Replace(customValidatorConfig, "test-header-equals", "missing-validator", 1)
+
+		if _, err := loadConfig(strings.NewReader(invalid)); !errors.Is(err, ErrUnknownValidator) {
+			tt.Fatalf("got '%v', want '%v'", err, ErrUnknownValidator)
+		}
+	})
+}
+
+func TestCompositeValidator(t *testing.T) {
+	passing := Auth{Validator: ListValidator, Token: []string{"alpha"}}
+	failing := Auth{Validator: ListValidator, Token: []string{"beta"}}
+
+	tests := []struct {
+		name string
+		auth Auth
+		want error
+	}{
+		{"all passes if every validator passes", Auth{All: []Auth{passing, passing}}, nil},
+		{"all fails if one validator fails", Auth{All: []Auth{passing, failing}}, ErrAuthFailed},
+		{"any passes if one validator passes", Auth{Any: []Auth{failing, passing}}, nil},
+		{"any fails if no validator passes", Auth{Any: []Auth{failing, failing}}, ErrAuthFailed},
+		{"rules can be nested", Auth{Any: []Auth{failing, {All: []Auth{passing, passing}}}}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			validator, err := newValidator(ValidatorParams{Label: "auth", Auth: test.auth})
+			if err != nil {
+				tt.Fatalf("could not create validator: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.Header.Set(TokenHeaderField, "alpha")
+
+			if err := validator.Validate(context.Background(), req, nil); !errors.Is(err, test.want) {
+				tt.Fatalf("got '%v', want '%v'", err, test.want)
+			}
+		})
+	}
+
+	t.Run("config reports the path of the failing rule", func(tt *testing.T) {
+		cfg := strings.Replace(customValidatorConfig, `
+    auth:
+      validator: test-header-equals
+      header-name: X-Custom
+      header-value: expected`, `
+    auth:
+      all:
+        - validator: list
+          token: [alpha]
+        - validator: hmac`, 1)
+
+		_, err := loadConfig(strings.NewReader(cfg))
+
+		want := MustBeSetError{"handler[0].auth.all[1].secret"}
+		if !errors.Is(err, want) {
+			tt.Fatalf("got '%v', want '%v'", err, want)
+		}
+	})
+
+	t.Run("validator and all can't be set together", func(tt *testing.T) {
+		auth := Auth{Validator: ListValidator, Token: []string{"alpha"}, All: []Auth{passing}}
+
+		_, err := newValidator(ValidatorParams{Label: "auth", Auth: auth})
+		if !errors.As(err, &ConflictingFieldsError{}) {
+			tt.Fatalf("expected ConflictingFieldsError, got '%v'", err)
+		}
+	})
+}
+
+func TestValidateHMAC(t *testing.T) {
+	const secret = "some-secret"
+
+	body := []byte(`{"ref": "refs/heads/main"}`)
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	authCfg := Auth{Validator: HMACValidator, Secret: secret}
+	setHMACDefaults(&authCfg)
+
+	validator, err := newValidator(ValidatorParams{Handler: "test", Label: "auth", Auth: authCfg, Logger: logger})
+	if err != nil {
+		t.Fatalf("could not create validator: %v", err)
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name   string
+		header string
+		want   error
+	}{
+		{"valid signature", signature, nil},
+		{"valid signature with prefix", "sha256=" + signature, nil},
+		{"missing signature", "", ErrAuthFailed},
+		{"invalid encoding", "not-hex", ErrAuthFailed},
+		{"wrong signature", hex.EncodeToString([]byte("wrong")), ErrAuthFailed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+			if test.header != "" {
+				req.Header.Set(defaultHMACHeader, test.header)
+			}
+
+			err := validator.Validate(context.Background(), req, body)
+			if !errors.Is(err, test.want) {
+				tt.Fatalf("got '%v', want '%v'", err, test.want)
+			}
+		})
+	}
+}
+
+func TestIPValidator(t *testing.T) {
+	auth := Auth{
+		Validator:      IPValidator,
+		Allow:          []string{"192.0.2.0/24", "2001:db8::1"},
+		TrustedProxies: []string{"10.0.0.1"},
+	}
+
+	validator, err := newValidator(ValidatorParams{Label: "auth", Auth: auth})
+	if err != nil {
+		t.Fatalf("could not create validator: %v", err)
+	}
+
+	tests := []ipValidatorTest{
+		{"allowed peer", "192.0.2.10:4000", nil, nil},
+		{"allowed ipv6 peer", "[2001:db8::1]:4000", nil, nil},
+		{"disallowed peer", "198.51.100.1:4000", nil, ErrAuthFailed},
+		{
+			"untrusted peer can't spoof headers",
+			"198.51.100.1:4000",
+			map[string]string{ForwardedForHeader: "192.0.2.10"},
+			ErrAuthFailed,
+		},
+		{
+			"trusted proxy forwards client",
+			"10.0.0.1:4000",
+			map[string]string{ForwardedForHeader: "192.0.2.10"},
+			nil,
+		},
+		{
+			"spoofed hops before the proxy are ignored",
+			"10.0.0.1:4000",
+			map[string]string{ForwardedForHeader: "192.0.2.10, 198.51.100.1"},
+			ErrAuthFailed,
+		},
+		{
+			"client sent forwarded header is ignored",
+			"10.0.0.1:4000",
+			map[string]string{
+				ForwardedHeader:    "for=192.0.2.10",
+				ForwardedForHeader: "198.51.100.1",
+			},
+			ErrAuthFailed,
+		},
+	}
+
+	runIPValidatorTests(t, validator, tests)
+
+	t.Run("forwarded header", func(tt *testing.T) {
+		auth := auth
+		auth.ForwardedHeader = "forwarded"
+
+		validator, err := newValidator(ValidatorParams{Label: "auth", Auth: auth})
+		if err != nil {
+			tt.Fatalf("could not create validator: %v", err)
+		}
+
+		runIPValidatorTests(tt, validator, []ipValidatorTest{
+			{
+				"trusted proxy forwards client",
+				"10.0.0.1:4000",
+				map[string]string{ForwardedHeader: `for="[2001:db8::1]:4711";proto=https`},
+				nil,
+			},
+			{
+				"client sent x-forwarded-for header is ignored",
+				"10.0.0.1:4000",
+				map[string]string{
+					ForwardedHeader:    "for=198.51.100.1",
+					ForwardedForHeader: "192.0.2.10",
+				},
+				ErrAuthFailed,
+			},
+		})
+	})
+
+	t.Run("unknown forwarded header", func(tt *testing.T) {
+		auth := auth
+		auth.ForwardedHeader = "x-real-ip"
+
+		if _, err := newValidator(ValidatorParams{Label: "auth", Auth: auth}); err == nil {
+			tt.Fatalf("error: should've failed")
+		}
+	})
+}
+
+type ipValidatorTest struct {
+	name       string
+	remoteAddr string
+	headers    map[string]string
+	want       error
+}
+
+func runIPValidatorTests(t *testing.T, validator Validator, tests []ipValidatorTest) {
+	t.Helper()
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.RemoteAddr = test.remoteAddr
+
+			for key, value := range test.headers {
+				req.Header.Set(key, value)
+			}
+
+			if err := validator.Validate(context.Background(), req, nil); !errors.Is(err, test.want) {
+				tt.Fatalf("got '%v', want '%v'", err, test.want)
+			}
+		})
+	}
+}
+
+func TestListValidatorTokens(t *testing.T) {
+	const token = "alpha"
+
+	sum := sha256.Sum256([]byte(token))
+
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte(token), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("could not hash token: %v", err)
+	}
+
+	salt := []byte("some-salt")
+	argon2Hash := fmt.Sprintf(
+		"$argon2id$v=%d$m=1024,t=1,p=1$%s$%s",
+		argon2.Version,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(argon2.IDKey([]byte(token), salt, 1, 1024, 1, 32)),
+	)
+
+	tokenFile := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(tokenFile, []byte("# deploy tokens\n\nsome-other-token\n"+token+"\n"), filePerms); err != nil {
+		t.Fatalf("could not write token file: %v", err)
+	}
+
+	t.Setenv("PIRATE_TEST_TOKEN", token)
+
+	tests := []struct {
+		name string
+		auth Auth
+	}{
+		{"plaintext", Auth{Token: []string{token}}},
+		{"sha256", Auth{Token: []string{"sha256:" + hex.EncodeToString(sum[:])}}},
+		{"bcrypt", Auth{Token: []string{"bcrypt:" + string(bcryptHash)}}},
+		{"argon2", Auth{Token: []string{"argon2:" + argon2Hash}}},
+		{"token file", Auth{TokenFile: tokenFile}},
+		{"token env", Auth{TokenEnv: "PIRATE_TEST_TOKEN"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			test.auth.Validator = ListValidator
+
+			validator, err := newValidator(ValidatorParams{Label: "auth", Auth: test.auth})
+			if err != nil {
+				tt.Fatalf("could not create validator: %v", err)
+			}
+
+			for _, tc := range []struct {
+				token string
+				want  error
+			}{
+				{token, nil},
+				{"wrong-token", ErrAuthFailed},
+				{"", ErrAuthFailed},
+			} {
+				req := httptest.NewRequest(http.MethodPost, "/", nil)
+				req.Header.Set(TokenHeaderField, tc.token)
+
+				if err := validator.Validate(context.Background(), req, nil); !errors.Is(err, tc.want) {
+					tt.Fatalf("(token=%s) got '%v', want '%v'", tc.token, err, tc.want)
+				}
+			}
+		})
+	}
+
+	t.Run("invalid hashes fail", func(tt *testing.T) {
+		for _, entry := range []string{"sha256:not-hex", "bcrypt:not-a-hash", "argon2:$argon2id$v=19$broken"} {
+			auth := Auth{Validator: ListValidator, Token: []string{entry}}
+
+			if _, err := newValidator(ValidatorParams{Label: "auth", Auth: auth}); err == nil {
+				tt.Fatalf("(%s) should've failed", entry)
+			}
+		}
+	})
+
+	t.Run("missing env var fails", func(tt *testing.T) {
+		auth := Auth{Validator: ListValidator, TokenEnv: "PIRATE_TEST_MISSING_TOKEN"}
+
+		if _, err := newValidator(ValidatorParams{Label: "auth", Auth: auth}); err == nil {
+			tt.Fatalf("should've failed")
+		}
+	})
+}
+
+func TestClientCertValidator(t *testing.T) {
+	auth := Auth{Validator: ClientCertValidator, Subjects: []string{"deployer", "ci.internal"}}
+
+	validator, err := newValidator(ValidatorParams{Label: "auth", Auth: auth})
+	if err != nil {
+		t.Fatalf("could not create validator: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		state *tls.ConnectionState
+		want  error
+	}{
+		{"plain http fails", nil, ErrAuthFailed},
+		{"unverified certificate fails", &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "deployer"}}},
+		}, ErrAuthFailed},
+		{"allowed common name", verifiedState(&x509.Certificate{Subject: pkix.Name{CommonName: "deployer"}}), nil},
+		{"allowed dns name", verifiedState(&x509.Certificate{DNSNames: []string{"ci.internal"}}), nil},
+		{"disallowed subject", verifiedState(&x509.Certificate{Subject: pkix.Name{CommonName: "intruder"}}), ErrAuthFailed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.TLS = test.state
+
+			if err := validator.Validate(context.Background(), req, nil); !errors.Is(err, test.want) {
+				tt.Fatalf("got '%v', want '%v'", err, test.want)
+			}
+		})
+	}
+}
+
+func verifiedState(cert *x509.Certificate) *tls.ConnectionState {
+	return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+}
+
+func TestCommandValidatorMetadata(t *testing.T) {
+	auth := Auth{
+		Validator: CommandValidator,
+		ExportEnv: true,
+		Run: `
+body="$(cat)"
+test "$body" = '{"ref": "main"}'
+test "$PIRATE_METHOD" = "POST"
+test "$PIRATE_PATH" = "/deploy"
+test "$PIRATE_QUERY_STRING" = "env=prod"
+test "$PIRATE_HEADERS_X_SIGNATURE" = "abc"
+echo "not an env line"
+echo "DEPLOY_ENV=prod"
+`,
+	}
+
+	validator, err := newValidator(ValidatorParams{Handler: "test", Label: "auth", Auth: auth})
+	if err != nil {
+		t.Fatalf("could not create validator: %v", err)
+	}
+
+	body := []byte(`{"ref": "main"}`)
+
+	req := httptest.NewRequest(http.MethodPost, "/deploy?env=prod", bytes.NewReader(body))
+	req.Header.Set("X-Signature", "abc")
+
+	ctx, env := withValidatorEnv(context.Background())
+
+	if err := validator.Validate(ctx, req, body); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := env.Vars()
+	want := []string{"DEPLOY_ENV=prod"}
+
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestCommandValidatorUserAndLimits(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("switching users requires root")
+	}
+
+	if _, err := user.Lookup("nobody"); err != nil {
+		t.Skip("user nobody does not exist")
+	}
+
+	if _, err := exec.LookPath(prlimitPath); err != nil {
+		t.Skip("prlimit is not installed")
+	}
+
+	auth := Auth{
+		Validator: CommandValidator,
+		Run:       `test "$(id -un)" = nobody && test "$(ulimit -Hn)" = 64`,
+	}
+
+	validator, err := newValidator(ValidatorParams{
+		Handler: "test",
+		Label:   "auth",
+		Auth:    Auth{Any: []Auth{auth}},
+		User:    "nobody",
+		Limits:  Limits{OpenFiles: 64},
+	})
+	if err != nil {
+		t.Fatalf("could not create validator: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+
+	if err := validator.Validate(context.Background(), req, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestCommandValidatorArgv(t *testing.T) {
+	auth := Auth{
+		Validator: CommandValidator,
+		Command:   []string{"test", "${PIRATE_TOKEN}", "=", "alpha beta"},
+	}
+
+	validator, err := newValidator(ValidatorParams{Handler: "test", Label: "auth", Auth: auth})
+	if err != nil {
+		t.Fatalf("could not create validator: %v", err)
+	}
+
+	for token, wantErr := range map[string]bool{"alpha beta": false, "alpha": true, "$(echo alpha beta)": true} {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set(TokenHeaderField, token)
+
+		if err := validator.Validate(context.Background(), req, nil); (err != nil) != wantErr {
+			t.Fatalf("(token=%s) got error %v, want error: %v", token, err, wantErr)
+		}
+	}
+
+	t.Run("run and command are mutually exclusive", func(tt *testing.T) {
+		auth := Auth{Validator: CommandValidator, Run: "true", Command: []string{"true"}}
+
+		if _, err := newValidator(ValidatorParams{Handler: "test", Label: "auth", Auth: auth}); err == nil {
+			tt.Fatalf("error: should've failed")
+		}
+	})
+}